@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/service"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const digestsUsage = `usage:
+  export-word digests list [-limit N]
+  export-word digests show <id>
+  export-word digests resend [-to a@example.com,b@example.com] [-sink email,telegram] <id>`
+
+// runDigests runs the digest history subcommand with the arguments after "digests".
+func runDigests(ctx context.Context, s *service.Service, args []string) error {
+	if len(args) == 0 {
+		return errors.New(digestsUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("digests list", flag.ContinueOnError)
+		limit := fs.Int("limit", 20, "number of digests to list")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		return listDigests(ctx, s, *limit)
+	case "show":
+		if len(args) != 2 {
+			return errors.New(digestsUsage)
+		}
+		return showDigest(ctx, s, args[1])
+	case "resend":
+		fs := flag.NewFlagSet("digests resend", flag.ContinueOnError)
+		to := fs.String("to", "", "comma-separated recipients, the original ones when empty")
+		sinks := fs.String("sink", "", "comma-separated sinks, the recipients' own when empty")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New(digestsUsage)
+		}
+		queued, err := s.Resend(ctx, fs.Arg(0), splitList(*to), splitList(*sinks))
+		if err != nil {
+			return err
+		}
+		s.DeliverOutbox(ctx)
+		fmt.Printf("queued %d deliveries\n", queued)
+		return showDigest(ctx, s, fs.Arg(0))
+	default:
+		return errors.New(digestsUsage)
+	}
+}
+
+func listDigests(ctx context.Context, s *service.Service, limit int) error {
+	digests, err := s.Digests(ctx, limit)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED\tWORDS\tFORMAT\tRECIPIENTS")
+	for _, d := range digests {
+		fmt.Fprintf(
+			tw, "%s\t%s\t%d\t%s\t%s\n",
+			d.ID, d.CreatedAt.Local().Format(time.DateTime), d.Count, digestFormat(d),
+			strings.Join(d.Recipients, ","),
+		)
+	}
+	return tw.Flush()
+}
+
+func showDigest(ctx context.Context, s *service.Service, id string) error {
+	d, deliveries, err := s.Digest(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("digest:  %s\n", d.ID)
+	fmt.Printf("created: %s\n", d.CreatedAt.Local().Format(time.DateTime))
+	fmt.Printf("words:   %d\n", d.Count)
+	fmt.Printf("format:  %s\n\n", digestFormat(d))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RECIPIENT\tSINK\tSTATUS\tATTEMPTS\tCREATED\tDELIVERED\tERROR")
+	for _, e := range deliveries {
+		delivered := "-"
+		if !e.DeliveredAt.IsZero() {
+			delivered = e.DeliveredAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(
+			tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			e.Recipient, e.Sink, e.Status, e.Attempts, e.CreatedAt.Local().Format(time.DateTime),
+			delivered, e.LastError,
+		)
+	}
+	return tw.Flush()
+}
+
+func digestFormat(d entity.Digest) string {
+	if len(d.Formats) > 0 {
+		return strings.Join(d.Formats, "+") + "." + d.Archive
+	}
+	return d.Format
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/fentezi/export-word/internal/config"
 	"github.com/fentezi/export-word/internal/repository"
 	"github.com/fentezi/export-word/internal/service"
@@ -25,9 +26,24 @@ func main() {
 	repo, err := repository.New(ctx, cfg.Mongo, logger)
 	if err != nil {
 		logger.Error("failed to create repository", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
 	defer repo.Close(ctx)
 
+	if len(os.Args) > 1 && os.Args[1] == "digests" {
+		admin, err := service.NewAdmin(logger, cfg, repo)
+		if err != nil {
+			logger.Error("failed to create service", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		if err := runDigests(ctx, &admin, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			repo.Close(ctx)
+			os.Exit(1)
+		}
+		return
+	}
+
 	expWord, err := service.New(logger, cfg, repo)
 	if err != nil {
 		logger.Error("failed to create service", slog.String("error", err.Error()))
@@ -0,0 +1,123 @@
+package book
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	GroupAlpha = "alpha"
+	GroupTag   = "tag"
+
+	FormatMarkdown = "markdown"
+	FormatEPUB     = "epub"
+
+	otherLetter = "#"
+	untagged    = "Untagged"
+)
+
+var (
+	ErrUnknownGroup  = errors.New("unknown book grouping")
+	ErrUnknownFormat = errors.New("unknown book format")
+)
+
+// Chapter is a section of the book: the words of one letter or one tag.
+type Chapter struct {
+	Title string
+	Words []entity.MongoMessage
+}
+
+// Book collects every stored word into a glossary grouped by first letter or by tag. Words are
+// kept in memory until the book is written, since both groupings need them sorted.
+type Book struct {
+	Title       string
+	Author      string
+	GeneratedAt time.Time
+	group       string
+	words       []entity.MongoMessage
+}
+
+func New(title, author, group string, now time.Time) (*Book, error) {
+	switch group {
+	case GroupAlpha, GroupTag:
+	default:
+		return nil, fmt.Errorf("book.New: %w: %q", ErrUnknownGroup, group)
+	}
+	return &Book{Title: title, Author: author, GeneratedAt: now, group: group}, nil
+}
+
+func (b *Book) Add(word entity.MongoMessage) {
+	b.words = append(b.words, word)
+}
+
+// Len returns the number of words in the book.
+func (b *Book) Len() int {
+	return len(b.words)
+}
+
+// Chapters groups the words and sorts chapters and words with the root collation, so that
+// accented and non-Latin letters sort next to their base letters regardless of language.
+// Words with several tags appear in every tag's chapter; words without tags come last.
+func (b *Book) Chapters() []Chapter {
+	col := collate.New(language.Und, collate.IgnoreCase)
+
+	byKey := make(map[string][]entity.MongoMessage)
+	for _, w := range b.words {
+		for _, key := range b.keys(w) {
+			byKey[key] = append(byKey[key], w)
+		}
+	}
+
+	chapters := make([]Chapter, 0, len(byKey))
+	for key, words := range byKey {
+		sort.SliceStable(words, func(i, j int) bool {
+			return col.CompareString(words[i].Word, words[j].Word) < 0
+		})
+		chapters = append(chapters, Chapter{Title: key, Words: words})
+	}
+	sort.Slice(chapters, func(i, j int) bool {
+		a, c := chapters[i].Title, chapters[j].Title
+		if last := b.lastChapter(); a == last || c == last {
+			return c == last && a != last
+		}
+		return col.CompareString(a, c) < 0
+	})
+	return chapters
+}
+
+func (b *Book) keys(word entity.MongoMessage) []string {
+	if b.group == GroupTag {
+		if len(word.Tags) == 0 {
+			return []string{untagged}
+		}
+		return word.Tags
+	}
+	return []string{letter(word.Word)}
+}
+
+// lastChapter is the catch-all chapter listed after the sorted ones.
+func (b *Book) lastChapter() string {
+	if b.group == GroupTag {
+		return untagged
+	}
+	return otherLetter
+}
+
+// letter returns the upper-cased first letter of the word without diacritics, so that "é"
+// files under "E", or "#" when the word starts with anything else.
+func letter(word string) string {
+	r, _ := utf8.DecodeRuneInString(norm.NFD.String(strings.TrimSpace(word)))
+	if !unicode.IsLetter(r) {
+		return otherLetter
+	}
+	return string(unicode.ToUpper(r))
+}
@@ -0,0 +1,193 @@
+package book
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var epubFuncs = template.FuncMap{
+	"xml": func(s string) string {
+		var buf bytes.Buffer
+		_ = xml.EscapeText(&buf, []byte(s))
+		return buf.String()
+	},
+	"join": strings.Join,
+	"inc":  func(i int) int { return i + 1 },
+}
+
+var epubTemplates = template.Must(template.New("epub").Funcs(epubFuncs).Parse(`
+{{- define "container" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles>
+</container>
+{{end}}
+
+{{- define "opf" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="id">urn:uuid:{{.ID}}</dc:identifier>
+<dc:title>{{xml .Book.Title}}</dc:title>
+<dc:creator>{{xml .Book.Author}}</dc:creator>
+<dc:language>und</dc:language>
+<dc:date>{{.Date}}</dc:date>
+<meta property="dcterms:modified">{{.Modified}}</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+<item id="css" href="style.css" media-type="text/css"/>
+{{- range $i, $c := .Chapters}}
+<item id="chapter-{{inc $i}}" href="chapter-{{inc $i}}.xhtml" media-type="application/xhtml+xml"/>
+{{- end}}
+</manifest>
+<spine toc="ncx">
+<itemref idref="nav"/>
+{{- range $i, $c := .Chapters}}
+<itemref idref="chapter-{{inc $i}}"/>
+{{- end}}
+</spine>
+</package>
+{{end}}
+
+{{- define "nav" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{xml .Book.Title}}</title><link rel="stylesheet" href="style.css"/></head>
+<body>
+<h1>{{xml .Book.Title}}</h1>
+<p>{{.Book.Len}} words, generated {{.Date}}.</p>
+<nav epub:type="toc" id="toc">
+<h2>Contents</h2>
+<ol>
+{{- range $i, $c := .Chapters}}
+<li><a href="chapter-{{inc $i}}.xhtml">{{xml $c.Title}}</a></li>
+{{- end}}
+</ol>
+</nav>
+</body>
+</html>
+{{end}}
+
+{{- define "ncx" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<head><meta name="dtb:uid" content="urn:uuid:{{.ID}}"/></head>
+<docTitle><text>{{xml .Book.Title}}</text></docTitle>
+<navMap>
+{{- range $i, $c := .Chapters}}
+<navPoint id="chapter-{{inc $i}}" playOrder="{{inc $i}}">
+<navLabel><text>{{xml $c.Title}}</text></navLabel>
+<content src="chapter-{{inc $i}}.xhtml"/>
+</navPoint>
+{{- end}}
+</navMap>
+</ncx>
+{{end}}
+
+{{- define "chapter" -}}
+<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>{{xml .Title}}</title><link rel="stylesheet" href="style.css"/></head>
+<body>
+<h2>{{xml .Title}}</h2>
+<dl>
+{{- range .Words}}
+<dt>{{xml .Word}}</dt>
+<dd>{{xml .Translation}}
+{{- if .Example}}<br/><i>{{xml .Example}}</i>{{end}}
+{{- if .Tags}}<br/><small>{{xml (join .Tags ", ")}}</small>{{end}}</dd>
+{{- end}}
+</dl>
+</body>
+</html>
+{{end}}
+`))
+
+// epubFile is a document of the book rendered from the named template.
+type epubFile struct {
+	name     string
+	template string
+	data     any
+}
+
+const epubStyle = `body { font-family: serif; }
+dt { font-weight: bold; margin-top: 0.8em; }
+dd { margin-left: 1.5em; }
+small { color: #666; }
+`
+
+// WriteEPUB renders the book as an EPUB 3 file with one XHTML document per chapter. The
+// navigation document and an NCX table of contents are both included so that EPUB 2 readers
+// also show the chapters.
+func (b *Book) WriteEPUB(w io.Writer) error {
+	const op = "book.WriteEPUB"
+
+	data := struct {
+		ID       string
+		Book     *Book
+		Chapters []Chapter
+		Date     string
+		Modified string
+	}{
+		ID:       uuid.NewString(),
+		Book:     b,
+		Chapters: b.Chapters(),
+		Date:     b.GeneratedAt.Format("2006-01-02"),
+		Modified: b.GeneratedAt.UTC().Format(time.RFC3339),
+	}
+
+	zw := zip.NewWriter(w)
+	// The mimetype must be the first entry and stored uncompressed.
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	files := []epubFile{
+		{"META-INF/container.xml", "container", nil},
+		{"OEBPS/content.opf", "opf", data},
+		{"OEBPS/nav.xhtml", "nav", data},
+		{"OEBPS/toc.ncx", "ncx", data},
+	}
+	for i, ch := range data.Chapters {
+		files = append(files, epubFile{fmt.Sprintf("OEBPS/chapter-%d.xhtml", i+1), "chapter", ch})
+	}
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if err := epubTemplates.ExecuteTemplate(fw, f.template, f.data); err != nil {
+			return fmt.Errorf("%s: %s: %w", op, f.name, err)
+		}
+	}
+	style, err := zw.Create("OEBPS/style.css")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := io.WriteString(style, epubStyle); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
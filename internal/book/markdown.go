@@ -0,0 +1,41 @@
+package book
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`,
+	"#", `\#`, "|", `\|`, "\r\n", " ", "\n", " ",
+)
+
+// WriteMarkdown renders the book as a Markdown document with a level-two heading per chapter.
+func (b *Book) WriteMarkdown(w io.Writer) error {
+	const op = "book.WriteMarkdown"
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n\n", markdownEscaper.Replace(b.Title))
+	fmt.Fprintf(bw, "_%d words, generated %s._\n", b.Len(), b.GeneratedAt.Format("2006-01-02"))
+
+	for _, ch := range b.Chapters() {
+		fmt.Fprintf(bw, "\n## %s\n\n", markdownEscaper.Replace(ch.Title))
+		for _, word := range ch.Words {
+			fmt.Fprintf(
+				bw, "- **%s** — %s",
+				markdownEscaper.Replace(word.Word), markdownEscaper.Replace(word.Translation),
+			)
+			if word.Example != "" {
+				fmt.Fprintf(bw, "  \n  _%s_", markdownEscaper.Replace(word.Example))
+			}
+			bw.WriteString("\n")
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Package brokertest provides an in-process stand-in for the Kafka consumer: a channel-backed
+// message source that records commits, for tests of the code consuming messages.
+package brokertest
+
+import (
+	"context"
+	"errors"
+	"github.com/fentezi/export-word/internal/kafka"
+	"io"
+	"sync"
+)
+
+// ErrClosed is returned by Commit after Close.
+var ErrClosed = errors.New("source closed")
+
+// Source delivers the produced messages in order. It implements the Fetch, Commit and Close
+// methods of the Kafka consumer.
+type Source struct {
+	ch      chan broker.Message
+	offsets map[string]int64
+
+	mu        sync.Mutex
+	fetched   int
+	committed []broker.Message
+	commitErr error
+	rejected  int
+	closed    bool
+	ended     bool
+}
+
+// NewSource returns a source holding up to buffer produced messages that were not fetched yet.
+func NewSource(buffer int) *Source {
+	return &Source{ch: make(chan broker.Message, buffer), offsets: make(map[string]int64)}
+}
+
+// Produce queues a message with each value on partition 0 of the topic, with consecutive
+// offsets per topic. It blocks while the buffer is full.
+func (s *Source) Produce(topic string, values ...string) {
+	for _, v := range values {
+		s.mu.Lock()
+		offset := s.offsets[topic]
+		s.offsets[topic]++
+		s.mu.Unlock()
+		s.ch <- broker.Message{Topic: topic, Offset: offset, Value: []byte(v)}
+	}
+}
+
+// End makes Fetch return io.EOF once the produced messages are fetched, as a closed consumer
+// does.
+func (s *Source) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.ended = true
+		close(s.ch)
+	}
+}
+
+// FailCommits makes every following Commit return err; nil lets them succeed again.
+func (s *Source) FailCommits(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commitErr = err
+}
+
+// Fetch returns the next produced message. It blocks until there is one, the source has ended
+// or ctx is done.
+func (s *Source) Fetch(ctx context.Context) (broker.Message, error) {
+	select {
+	case <-ctx.Done():
+		return broker.Message{}, ctx.Err()
+	case msg, ok := <-s.ch:
+		if !ok {
+			return broker.Message{}, io.EOF
+		}
+		s.mu.Lock()
+		s.fetched++
+		s.mu.Unlock()
+		return msg, nil
+	}
+}
+
+// Commit records the messages as consumed.
+func (s *Source) Commit(_ context.Context, msgs ...broker.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.commitErr != nil {
+		s.rejected++
+		return s.commitErr
+	}
+	s.committed = append(s.committed, msgs...)
+	return nil
+}
+
+// Close marks the source as closed.
+func (s *Source) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Fetched returns the number of messages fetched so far.
+func (s *Source) Fetched() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetched
+}
+
+// Committed returns the committed messages in commit order.
+func (s *Source) Committed() []broker.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]broker.Message(nil), s.committed...)
+}
+
+// Rejected returns the number of commits that failed with the error set by FailCommits.
+func (s *Source) Rejected() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rejected
+}
+
+// Closed reports whether Close was called.
+func (s *Source) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/ilyakaznacheev/cleanenv"
 	"github.com/joho/godotenv"
 	"os"
+	"time"
 )
 
 type Config struct {
@@ -12,13 +13,89 @@ type Config struct {
 	Server Server `yaml:"server"`
 	Kafka  Kafka  `yaml:"kafka"`
 	Mongo  Mongo  `yaml:"mongo"`
+	SRS    SRS    `yaml:"srs"`
+	Review Review `yaml:"review"`
+	Digest Digest `yaml:"digest"`
+	Export Export `yaml:"export"`
+	Stats  Stats  `yaml:"stats"`
+	Outbox Outbox `yaml:"outbox"`
+	Mail   Mail   `yaml:"mail"`
 	Gmail  Gmail
+	// Subscribers receive the digest. When empty, it is sent to Gmail.Email only.
+	Subscribers []Subscriber `yaml:"subscribers"`
+	// Sinks are the destinations subscribers choose from besides the built-in "email" sink.
+	Sinks []Sink `yaml:"sinks"`
+}
+
+// SubscriberList returns the configured subscribers or the Gmail account itself.
+func (c Config) SubscriberList() []Subscriber {
+	if len(c.Subscribers) > 0 {
+		return c.Subscribers
+	}
+	return []Subscriber{{Email: c.Gmail.Email}}
 }
 
 type Kafka struct {
-	Address string `yaml:"address"`
-	Port    string `yaml:"port"`
-	Topic   string `yaml:"topic"`
+	Address string    `yaml:"address"`
+	Port    string    `yaml:"port"`
+	Brokers []string  `yaml:"brokers" env:"KAFKA_BROKERS" env-separator:","`
+	Topic   string    `yaml:"topic"`
+	GroupID string    `yaml:"group_id" env:"KAFKA_GROUP_ID"`
+	Topics  []Topic   `yaml:"topics"`
+	Batch   Batch     `yaml:"batch"`
+	TLS     KafkaTLS  `yaml:"tls"`
+	SASL    KafkaSASL `yaml:"sasl"`
+}
+
+// TopicRules returns the configured topics, or the single legacy Topic when none are set.
+func (k Kafka) TopicRules() []Topic {
+	if len(k.Topics) > 0 {
+		return k.Topics
+	}
+	return []Topic{{Name: k.Topic}}
+}
+
+// Topic describes one topic, or a set of topics matched by Pattern, and how its messages are
+// mapped to entity.KafkaMessage.
+type Topic struct {
+	Name    string  `yaml:"name"`
+	Pattern string  `yaml:"pattern"`
+	Source  string  `yaml:"source"`
+	Mapping Mapping `yaml:"mapping"`
+}
+
+// Mapping holds dot-separated JSON paths for each field. Empty paths use the default field name.
+type Mapping struct {
+	EventID     string `yaml:"event_id"`
+	Word        string `yaml:"word"`
+	Translation string `yaml:"translation"`
+	Source      string `yaml:"source"`
+	LangFrom    string `yaml:"lang_from"`
+	LangTo      string `yaml:"lang_to"`
+	Example     string `yaml:"example"`
+	Tags        string `yaml:"tags"`
+}
+
+// Batch enables batched ingestion when Size is greater than one.
+type Batch struct {
+	Size    int           `yaml:"size" env:"KAFKA_BATCH_SIZE"`
+	Timeout time.Duration `yaml:"timeout" env:"KAFKA_BATCH_TIMEOUT" env-default:"500ms"`
+}
+
+type KafkaTLS struct {
+	Enabled            bool   `yaml:"enabled" env:"KAFKA_TLS_ENABLED"`
+	CAFile             string `yaml:"ca_file" env:"KAFKA_TLS_CA_FILE"`
+	CertFile           string `yaml:"cert_file" env:"KAFKA_TLS_CERT_FILE"`
+	KeyFile            string `yaml:"key_file" env:"KAFKA_TLS_KEY_FILE"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type KafkaSASL struct {
+	Mechanism    string `yaml:"mechanism" env:"KAFKA_SASL_MECHANISM"`
+	Username     string `yaml:"username" env:"KAFKA_SASL_USERNAME"`
+	Password     string `env:"KAFKA_SASL_PASSWORD"`
+	PasswordFile string `yaml:"password_file" env:"KAFKA_SASL_PASSWORD_FILE"`
 }
 
 type Mongo struct {
@@ -26,9 +103,274 @@ type Mongo struct {
 	Address  string `env:"MONGO_URL" env-required:"true"`
 }
 
+// SRS configures spaced repetition. DefaultGrade is applied to words that were mailed but not
+// reviewed.
+type SRS struct {
+	Algorithm    string  `yaml:"algorithm" env:"SRS_ALGORITHM" env-default:"sm2"`
+	Retention    float64 `yaml:"retention" env:"SRS_RETENTION" env-default:"0.9"`
+	DefaultGrade string  `yaml:"default_grade" env:"SRS_DEFAULT_GRADE" env-default:"good"`
+}
+
+// Review configures the signed review links. Links are only rendered when Secret is set.
+type Review struct {
+	Secret string        `env:"REVIEW_SECRET"`
+	TTL    time.Duration `yaml:"ttl" env:"REVIEW_TTL" env-default:"168h"`
+}
+
+type Digest struct {
+	BodyLimit int `yaml:"body_limit" env:"DIGEST_BODY_LIMIT" env-default:"200"`
+}
+
+// Subscriber is a recipient of the digest. Mode is "list" (default) or "quiz". Sinks names the
+// sinks the digest is delivered to, "email" when empty.
+type Subscriber struct {
+	Email string   `yaml:"email"`
+	Mode  string   `yaml:"mode"`
+	Quiz  Quiz     `yaml:"quiz"`
+	Sinks []string `yaml:"sinks"`
+	// PGPKey is a file with the OpenPGP public key the emailed digests are encrypted to.
+	PGPKey string `yaml:"pgp_key"`
+}
+
+// SinkList returns the names of the subscriber's sinks.
+func (s Subscriber) SinkList() []string {
+	if len(s.Sinks) > 0 {
+		return s.Sinks
+	}
+	return []string{"email"}
+}
+
+// Sink is a named destination for digests. Type is "email", "directory", "s3", "webhook",
+// "telegram" or "chat"; only the settings of the type are read. Secrets can be read from files
+// instead.
+type Sink struct {
+	Name      string        `yaml:"name"`
+	Type      string        `yaml:"type"`
+	Directory DirectorySink `yaml:"directory"`
+	S3        S3Sink        `yaml:"s3"`
+	Webhook   WebhookSink   `yaml:"webhook"`
+	Telegram  TelegramSink  `yaml:"telegram"`
+	Chat      ChatSink      `yaml:"chat"`
+}
+
+// DirectorySink copies the export file into Path, e.g. a mounted network share.
+type DirectorySink struct {
+	Path string `yaml:"path"`
+}
+
+// S3Sink uploads the export file to an S3-compatible bucket under Prefix. Insecure connects
+// over plain HTTP, for local stand-ins.
+type S3Sink struct {
+	Endpoint      string `yaml:"endpoint"`
+	Region        string `yaml:"region"`
+	Bucket        string `yaml:"bucket"`
+	Prefix        string `yaml:"prefix"`
+	AccessKey     string `yaml:"access_key"`
+	SecretKey     string `yaml:"secret_key"`
+	SecretKeyFile string `yaml:"secret_key_file"`
+	Insecure      bool   `yaml:"insecure"`
+}
+
+// WebhookSink posts the digest to URL, signed with HMAC-SHA256 of Secret. Timeout defaults to
+// 30 seconds.
+type WebhookSink struct {
+	URL        string        `yaml:"url"`
+	Secret     string        `yaml:"secret"`
+	SecretFile string        `yaml:"secret_file"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// TelegramSink sends the digest through a bot to ChatID. BaseURL defaults to the public Bot API
+// and can point at a local fake. Timeout defaults to 30 seconds.
+type TelegramSink struct {
+	Token     string        `yaml:"token"`
+	TokenFile string        `yaml:"token_file"`
+	ChatID    string        `yaml:"chat_id"`
+	BaseURL   string        `yaml:"base_url"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// ChatSink posts the digest to a Slack or Mattermost incoming webhook. Style is "slack" (Block
+// Kit, default) or "mattermost" (Markdown). Sample is the number of words listed, 10 when zero;
+// words matching a Highlight rule are listed first and marked. With WordOfTheDay, the first
+// of them, or a random word, is featured on top.
+type ChatSink struct {
+	URL          string        `yaml:"url"`
+	URLFile      string        `yaml:"url_file"`
+	Style        string        `yaml:"style"`
+	Username     string        `yaml:"username"`
+	Sample       int           `yaml:"sample"`
+	WordOfTheDay bool          `yaml:"word_of_the_day"`
+	Highlight    []Highlight   `yaml:"highlight"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+// Highlight matches words by tag, source and language pair ("from-to"); empty fields match
+// any word. Matching words are marked with Emoji, ":star:" when empty.
+type Highlight struct {
+	Tag    string `yaml:"tag"`
+	Source string `yaml:"source"`
+	Lang   string `yaml:"lang"`
+	Emoji  string `yaml:"emoji"`
+}
+
+// Quiz configures the quiz digest. Direction is "forward" (word to translation, default),
+// "reverse" or "mixed". Prompts limits the number of questions, zero uses digest.body_limit.
+// Answers is "inline" (default, in a collapsible section) or "attachment" (an answer key file).
+// The export file lists every answer and is only delivered along with the quiz when Export is
+// set.
+type Quiz struct {
+	Direction string `yaml:"direction"`
+	Prompts   int    `yaml:"prompts"`
+	Answers   string `yaml:"answers"`
+	Export    bool   `yaml:"export"`
+}
+
+// AttachExport reports whether the export file is delivered to the subscriber.
+func (s Subscriber) AttachExport() bool {
+	return s.Mode != "quiz" || s.Quiz.Export
+}
+
+// Export selects the format of the digest attachment: "csv", "pdf", "xlsx", "quizlet",
+// "memrise" or "mnemosyne". When Archive is "zip" or "tar.gz", the digest is written in every
+// format of Formats (Format alone when empty) and attached as one archive with a manifest.
+type Export struct {
+	Format    string    `yaml:"format" env:"EXPORT_FORMAT" env-default:"csv"`
+	Formats   []string  `yaml:"formats" env:"EXPORT_FORMATS" env-separator:","`
+	Archive   string    `yaml:"archive" env:"EXPORT_ARCHIVE"`
+	PDF       PDF       `yaml:"pdf"`
+	Quizlet   Quizlet   `yaml:"quizlet"`
+	Memrise   Memrise   `yaml:"memrise"`
+	Mnemosyne Mnemosyne `yaml:"mnemosyne"`
+	Book      Book      `yaml:"book"`
+	Workspace Workspace `yaml:"workspace"`
+}
+
+// Workspace configures where exports are written. Every export gets its own directory under
+// Dir, "export-word" in the system temporary directory by default. With a zero Retention an
+// export is removed once it has been sent; otherwise exports are kept that long.
+type Workspace struct {
+	Dir       string        `yaml:"dir" env:"EXPORT_DIR"`
+	Retention time.Duration `yaml:"retention" env:"EXPORT_RETENTION"`
+}
+
+// FormatList returns the formats bundled into the archive.
+func (e Export) FormatList() []string {
+	if len(e.Formats) > 0 {
+		return e.Formats
+	}
+	return []string{e.Format}
+}
+
+// Book configures the cumulative vocabulary book served at GET /book. Group is "alpha" (by
+// first letter, default) or "tag"; both can be overridden per request.
+type Book struct {
+	Title  string `yaml:"title" env-default:"Vocabulary"`
+	Author string `yaml:"author" env-default:"export-word"`
+	Group  string `yaml:"group" env-default:"alpha"`
+}
+
+// Quizlet sets the delimiters chosen in Quizlet's import dialog. Besides any literal string,
+// "tab", "comma", "semicolon" and "newline" are accepted.
+type Quizlet struct {
+	TermSeparator string `yaml:"term_separator" env-default:"tab"`
+	RowSeparator  string `yaml:"row_separator" env-default:"newline"`
+}
+
+// Memrise configures the CSV for Memrise. LevelBy selects what groups words into levels:
+// "tag" (first tag, default), "language" (language pair) or "date" (day the word was added).
+type Memrise struct {
+	Delimiter string `yaml:"delimiter" env-default:"comma"`
+	LevelBy   string `yaml:"level_by" env-default:"tag"`
+}
+
+// Mnemosyne configures the Mnemosyne 1.x XML export. Words without tags go to Category.
+type Mnemosyne struct {
+	Category string `yaml:"category" env-default:"export-word"`
+}
+
+// PDF configures the flashcard layout. Font and CJKFont are TrueType files; Font defaults to
+// the embedded Go font, which covers Latin, Greek and Cyrillic. CJKFont is used for text
+// containing Chinese, Japanese or Korean characters; without it, or a Font that covers them,
+// words with such text get no card and are logged. The document is built in memory, so at most
+// MaxCards words get a card; the rest of a larger digest is left out and logged.
+type PDF struct {
+	PageSize string `yaml:"page_size" env-default:"A4"`
+	Columns  int    `yaml:"columns" env-default:"2"`
+	Rows     int    `yaml:"rows" env-default:"4"`
+	MaxCards int    `yaml:"max_cards" env-default:"1000"`
+	Font     string `yaml:"font" env:"EXPORT_PDF_FONT"`
+	CJKFont  string `yaml:"cjk_font" env:"EXPORT_PDF_CJK_FONT"`
+}
+
+// Outbox configures the retries of digest deliveries. Attempt n is retried after
+// BaseDelay*2^(n-1), at most MaxDelay, with up to half of it taken off at random; a delivery
+// is given up after MaxAttempts. Lease is how long a delivery in progress is reserved for the
+// worker sending it.
+type Outbox struct {
+	PollInterval time.Duration `yaml:"poll_interval" env-default:"1m"`
+	MaxAttempts  int           `yaml:"max_attempts" env-default:"10"`
+	BaseDelay    time.Duration `yaml:"base_delay" env-default:"30s"`
+	MaxDelay     time.Duration `yaml:"max_delay" env-default:"1h"`
+	Lease        time.Duration `yaml:"lease" env-default:"5m"`
+}
+
+// Mail configures the mail sender: the SMTP relay, Gmail by default, DKIM signing, the
+// List-Unsubscribe header and the send limits.
+type Mail struct {
+	Host        string      `yaml:"host" env:"MAIL_SMTP_HOST" env-default:"smtp.gmail.com"`
+	Port        int         `yaml:"port" env:"MAIL_SMTP_PORT" env-default:"587"`
+	DKIM        DKIM        `yaml:"dkim"`
+	Unsubscribe Unsubscribe `yaml:"unsubscribe"`
+	Limit       MailLimit   `yaml:"limit"`
+}
+
+// DKIM signs outgoing mail when KeyFile is set. KeyFile holds a PEM encoded RSA or Ed25519
+// private key whose public key is published at Selector._domainkey.Domain. Domain defaults to
+// the domain of the sender address. Headers are the fields to sign, a sensible set when empty.
+type DKIM struct {
+	Domain   string   `yaml:"domain" env:"MAIL_DKIM_DOMAIN"`
+	Selector string   `yaml:"selector" env:"MAIL_DKIM_SELECTOR"`
+	KeyFile  string   `yaml:"key_file" env:"MAIL_DKIM_KEY_FILE"`
+	Headers  []string `yaml:"headers"`
+}
+
+// Unsubscribe sets the List-Unsubscribe header to the mailto address and the URL, whichever
+// are set. OneClick adds List-Unsubscribe-Post (RFC 8058), which requires an https URL.
+type Unsubscribe struct {
+	Mailto   string `yaml:"mailto"`
+	URL      string `yaml:"url"`
+	OneClick bool   `yaml:"one_click"`
+}
+
+// MailLimit throttles the mail sender with a token bucket of Burst messages refilled at Rate
+// messages per minute, and caps the recipients per calendar day in Timezone at DailyQuota.
+// The daily count is stored in MongoDB, so restarts do not reset it. Sends over a limit are
+// deferred to the next window. A zero Rate or DailyQuota disables that limit.
+type MailLimit struct {
+	Rate       float64 `yaml:"rate" env:"MAIL_RATE" env-default:"20"`
+	Burst      int     `yaml:"burst" env-default:"5"`
+	DailyQuota int     `yaml:"daily_quota" env:"MAIL_DAILY_QUOTA" env-default:"450"`
+	Timezone   string  `yaml:"timezone" env-default:"UTC"`
+}
+
+// Stats configures the statistics endpoint and the optional weekly summary email, sent on
+// Weekday at Hour in Timezone.
+type Stats struct {
+	Timezone string `yaml:"timezone" env:"STATS_TIMEZONE" env-default:"UTC"`
+	Days     int    `yaml:"days" env-default:"30"`
+	Weekly   bool   `yaml:"weekly" env:"STATS_WEEKLY"`
+	Weekday  string `yaml:"weekday" env-default:"Monday"`
+	Hour     int    `yaml:"hour" env-default:"9"`
+}
+
+// Server configures the HTTP server. The statistics, book, expvar and digest history endpoints
+// are only served when AdminToken is set and require it as a bearer token.
 type Server struct {
-	Host string `yaml:"host"`
-	Port string `yaml:"port"`
+	Host       string `yaml:"host"`
+	Port       string `yaml:"port"`
+	PublicURL  string `yaml:"public_url" env:"SERVER_PUBLIC_URL"`
+	AdminToken string `env:"SERVER_ADMIN_TOKEN"`
 }
 
 type Gmail struct {
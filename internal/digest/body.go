@@ -0,0 +1,65 @@
+package digest
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+var bodyTemplate = template.Must(template.New("body").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<p>{{.Total}} words to repeat today.</p>
+<table cellpadding="6" style="border-collapse: collapse;">
+{{- range .Items}}
+<tr style="border-bottom: 1px solid #ddd;">
+<td><b>{{.Word}}</b></td>
+<td>{{.Translation}}</td>
+{{- if .Links}}
+<td>{{range $i, $l := .Links}}{{if $i}} · {{end}}<a href="{{$l.URL}}">{{$l.Label}}</a>{{end}}</td>
+{{- end}}
+</tr>
+{{- end}}
+</table>
+{{- if .More}}
+<p>… and {{.More}} more in the attachment.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// Body collects the words of a digest for the email body. Only the first limit words are kept
+// so that large digests do not grow the message; the rest are counted and referred to the
+// attachment.
+type Body struct {
+	limit int
+	items []Item
+	total int
+}
+
+func NewBody(limit int) *Body {
+	return &Body{limit: limit}
+}
+
+func (b *Body) Add(item Item) {
+	b.total++
+	if b.limit <= 0 || len(b.items) < b.limit {
+		b.items = append(b.items, item)
+	}
+}
+
+func (b *Body) Render() (Content, error) {
+	const op = "digest.Body.Render"
+
+	var sb strings.Builder
+	err := bodyTemplate.Execute(&sb, struct {
+		Items []Item
+		Total int
+		More  int
+	}{Items: b.items, Total: b.total, More: b.total - len(b.items)})
+	if err != nil {
+		return Content{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return Content{HTML: sb.String()}, nil
+}
@@ -0,0 +1,50 @@
+package digest
+
+import (
+	"github.com/fentezi/export-word/internal/config"
+)
+
+const (
+	ModeList = "list"
+	ModeQuiz = "quiz"
+)
+
+// Link is an action rendered next to a word, such as a review grade.
+type Link struct {
+	Label string
+	URL   string
+}
+
+type Item struct {
+	Word        string
+	Translation string
+	Links       []Link
+}
+
+// Attachment is a file generated together with the email body.
+type Attachment struct {
+	Name string
+	Data []byte
+}
+
+// Content is the rendered email of one subscriber.
+type Content struct {
+	HTML        string
+	Attachments []Attachment
+	// Embeds are inline files referenced from the HTML as "cid:" + Name.
+	Embeds []Attachment
+}
+
+// Builder receives the words of the digest one at a time and renders the email.
+type Builder interface {
+	Add(item Item)
+	Render() (Content, error)
+}
+
+// NewBuilder returns the builder for the subscriber's digest mode.
+func NewBuilder(sub config.Subscriber, bodyLimit int) Builder {
+	if sub.Mode == ModeQuiz {
+		return NewQuiz(sub.Quiz, bodyLimit)
+	}
+	return NewBody(bodyLimit)
+}
@@ -0,0 +1,119 @@
+package digest
+
+import (
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"html/template"
+	"math/rand/v2"
+	"strings"
+)
+
+const (
+	DirectionForward = "forward"
+	DirectionReverse = "reverse"
+	DirectionMixed   = "mixed"
+
+	AnswersInline     = "inline"
+	AnswersAttachment = "attachment"
+
+	answerKeyName = "answers.txt"
+)
+
+var quizTemplate = template.Must(template.New("quiz").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<p>Quiz: {{len .Prompts}} of {{.Total}} words due today.</p>
+<ol>
+{{- range .Prompts}}
+<li><b>{{.Prompt}}</b>
+{{- if $.Inline}}
+<details><summary>Answer</summary>{{.Answer}}
+{{- if .Links}}<br>{{range $i, $l := .Links}}{{if $i}} · {{end}}<a href="{{$l.URL}}">{{$l.Label}}</a>{{end}}{{end}}
+</details>
+{{- end}}
+</li>
+{{- end}}
+</ol>
+{{- if not .Inline}}
+<p>The answers are in the attached {{.AnswerKey}}.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+type prompt struct {
+	Prompt string
+	Answer string
+	Links  []Link
+}
+
+// Quiz renders the digest as questions with hidden answers. When there are more words than
+// prompts, the prompts are a uniform random sample of the digest.
+type Quiz struct {
+	cfg     config.Quiz
+	limit   int
+	prompts []prompt
+	total   int
+}
+
+func NewQuiz(cfg config.Quiz, bodyLimit int) *Quiz {
+	limit := cfg.Prompts
+	if limit <= 0 {
+		limit = bodyLimit
+	}
+	return &Quiz{cfg: cfg, limit: limit}
+}
+
+func (q *Quiz) Add(item Item) {
+	q.total++
+	p := q.prompt(item)
+	if q.limit <= 0 || len(q.prompts) < q.limit {
+		q.prompts = append(q.prompts, p)
+		return
+	}
+	// Reservoir sampling keeps every word equally likely to be asked.
+	if i := rand.IntN(q.total); i < q.limit {
+		q.prompts[i] = p
+	}
+}
+
+func (q *Quiz) Render() (Content, error) {
+	const op = "digest.Quiz.Render"
+
+	inline := q.cfg.Answers != AnswersAttachment
+	var sb strings.Builder
+	err := quizTemplate.Execute(&sb, struct {
+		Prompts   []prompt
+		Total     int
+		Inline    bool
+		AnswerKey string
+	}{Prompts: q.prompts, Total: q.total, Inline: inline, AnswerKey: answerKeyName})
+	if err != nil {
+		return Content{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	content := Content{HTML: sb.String()}
+	if !inline {
+		content.Attachments = append(content.Attachments, q.answerKey())
+	}
+
+	return content, nil
+}
+
+func (q *Quiz) prompt(item Item) prompt {
+	reverse := q.cfg.Direction == DirectionReverse ||
+		(q.cfg.Direction == DirectionMixed && rand.IntN(2) == 1)
+	if reverse {
+		return prompt{Prompt: item.Translation, Answer: item.Word, Links: item.Links}
+	}
+	return prompt{Prompt: item.Word, Answer: item.Translation, Links: item.Links}
+}
+
+// answerKey lists the answers numbered like the questions in the email.
+func (q *Quiz) answerKey() Attachment {
+	var sb strings.Builder
+	for i, p := range q.prompts {
+		fmt.Fprintf(&sb, "%d. %s — %s\n", i+1, p.Prompt, p.Answer)
+	}
+	return Attachment{Name: answerKeyName, Data: []byte(sb.String())}
+}
@@ -0,0 +1,237 @@
+// Package dkim signs outgoing mail with DKIM (RFC 6376) using relaxed header and body
+// canonicalization and RSA-SHA256 or Ed25519-SHA256 (RFC 8463) keys.
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// lineLimit is the length the signature header is folded at.
+const lineLimit = 72
+
+// DefaultHeaders are the header fields signed when none are configured. Fields missing from a
+// message are skipped.
+var DefaultHeaders = []string{
+	"From", "To", "Subject", "Date", "Message-ID", "References", "MIME-Version", "Content-Type",
+	"List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported DKIM key")
+	ErrMalformed      = errors.New("malformed message")
+)
+
+// Signer signs messages for a domain with the key published under the selector.
+type Signer struct {
+	domain   string
+	selector string
+	key      crypto.Signer
+	algo     string
+	headers  []string
+}
+
+// New returns a signer for the key. headers are the fields to sign, DefaultHeaders when empty.
+func New(domain, selector string, key crypto.Signer, headers []string) (*Signer, error) {
+	const op = "dkim.New"
+
+	if domain == "" || selector == "" {
+		return nil, fmt.Errorf("%s: domain and selector are required", op)
+	}
+	var algo string
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		algo = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algo = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("%s: %w: %T", op, ErrUnsupportedKey, key)
+	}
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	return &Signer{
+		domain: domain, selector: selector, key: key, algo: algo, headers: headers,
+	}, nil
+}
+
+// LoadKey reads a PEM encoded RSA (PKCS #1 or PKCS #8) or Ed25519 (PKCS #8) private key.
+func LoadKey(path string) (crypto.Signer, error) {
+	const op = "dkim.LoadKey"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: %s: no PEM block", op, path)
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: %w: %s", op, ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w: %T", op, ErrUnsupportedKey, key)
+	}
+	return signer, nil
+}
+
+// Domain returns the signing domain.
+func (s *Signer) Domain() string {
+	return s.domain
+}
+
+// Sign returns the message with a DKIM-Signature header prepended. The message must use CRLF
+// line endings.
+func (s *Signer) Sign(msg []byte, now time.Time) ([]byte, error) {
+	const op = "dkim.Sign"
+
+	header, body, ok := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, fmt.Errorf("%s: %w: no header end", op, ErrMalformed)
+	}
+	fields := parseHeader(header)
+
+	bodyHash := sha256.Sum256(canonicalBody(body))
+
+	// Every field is signed in the order it appears, the last occurrence first as RFC 6376
+	// 5.4.2 requires for repeated names.
+	var signed []string
+	h := sha256.New()
+	used := make(map[int]bool)
+	for _, name := range s.headers {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fields[i].name, name) {
+				continue
+			}
+			used[i] = true
+			h.Write([]byte(canonicalHeader(fields[i].raw) + "\r\n"))
+			signed = append(signed, strings.ToLower(name))
+			break
+		}
+	}
+
+	sigField := fmt.Sprintf(
+		"DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d;\r\n\th=%s;\r\n\tbh=%s;\r\n\tb=",
+		s.algo, s.domain, s.selector, now.Unix(), strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]),
+	)
+	h.Write([]byte(canonicalHeader(sigField)))
+
+	var (
+		sig []byte
+		err error
+	)
+	if s.algo == "rsa-sha256" {
+		sig, err = s.key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	} else {
+		sig, err = s.key.Sign(rand.Reader, h.Sum(nil), crypto.Hash(0))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sigField)
+	out.WriteString(fold(base64.StdEncoding.EncodeToString(sig)))
+	out.WriteString("\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+type field struct {
+	name string
+	raw  string
+}
+
+// parseHeader splits the header block into fields, keeping folded lines with their field.
+func parseHeader(header []byte) []field {
+	var fields []field
+	for _, line := range strings.Split(string(header), "\r\n") {
+		if len(fields) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			fields[len(fields)-1].raw += "\r\n" + line
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, field{name: strings.TrimSpace(name), raw: line})
+	}
+	return fields
+}
+
+// canonicalHeader applies the relaxed header canonicalization to a field without its CRLF.
+func canonicalHeader(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(compactSpace(value))
+}
+
+// canonicalBody applies the relaxed body canonicalization.
+func canonicalBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(compactSpace(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// compactSpace replaces every run of spaces and tabs with a single space.
+func compactSpace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// fold breaks the signature value into continuation lines, which verifiers ignore.
+func fold(value string) string {
+	var b strings.Builder
+	for len(value) > lineLimit {
+		b.WriteString(value[:lineLimit])
+		b.WriteString("\r\n\t")
+		value = value[lineLimit:]
+	}
+	b.WriteString(value)
+	return b.String()
+}
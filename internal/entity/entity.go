@@ -2,17 +2,122 @@ package entity
 
 import (
 	"github.com/google/uuid"
+	"time"
 )
 
 type KafkaMessage struct {
 	EventID     uuid.UUID `json:"event_id"`
 	Word        string    `json:"word"`
 	Translation string    `json:"translation"`
+	Source      string    `json:"source"`
+	LangFrom    string    `json:"lang_from"`
+	LangTo      string    `json:"lang_to"`
+	Example     string    `json:"example"`
+	Tags        []string  `json:"tags"`
 }
 
+// MongoMessage is a stored word. PrevSRS is its state before it was last scheduled, by the
+// digest in ScheduledBy, with the default grade once the digest was delivered or with the
+// grade of a review link.
 type MongoMessage struct {
 	EventID     uuid.UUID `bson:"eventId"`
 	Word        string    `bson:"word"`
 	Translation string    `bson:"translation"`
+	Source      string    `bson:"source,omitempty"`
+	LangFrom    string    `bson:"langFrom,omitempty"`
+	LangTo      string    `bson:"langTo,omitempty"`
+	Example     string    `bson:"example,omitempty"`
+	Tags        []string  `bson:"tags,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt,omitempty"`
 	Sent        bool      `bson:"sent"`
+	SentAt      time.Time `bson:"sentAt,omitempty"`
+	DigestID    string    `bson:"digestId,omitempty"`
+	SRS         SRS       `bson:"srs"`
+	PrevSRS     *SRS      `bson:"prevSrs,omitempty"`
+	ScheduledBy string    `bson:"scheduledBy,omitempty"`
+	Reviews     []Review  `bson:"reviews,omitempty"`
+}
+
+// SRS is the spaced-repetition state of a word. Ease, Interval and Repetitions are used by
+// SM-2, Stability and Difficulty by FSRS.
+type SRS struct {
+	Due         time.Time `bson:"due"`
+	Interval    int       `bson:"interval"`
+	Repetitions int       `bson:"repetitions"`
+	Lapses      int       `bson:"lapses"`
+	Ease        float64   `bson:"ease,omitempty"`
+	Stability   float64   `bson:"stability,omitempty"`
+	Difficulty  float64   `bson:"difficulty,omitempty"`
+	LastReview  time.Time `bson:"lastReview,omitempty"`
+}
+
+// Review is a grade given through a review link of a digest. Before is the state the grade was
+// applied to and After the resulting state.
+type Review struct {
+	EventID    uuid.UUID `bson:"eventId"`
+	DigestID   string    `bson:"digestId"`
+	Grade      string    `bson:"grade"`
+	Before     SRS       `bson:"before"`
+	After      SRS       `bson:"after"`
+	ReviewedAt time.Time `bson:"reviewedAt"`
+}
+
+// Count is one group of an aggregation, such as the words added on a day.
+type Count struct {
+	Key   string `bson:"_id" json:"key"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxFailed    = "failed"
+)
+
+// OutboxEntry is the delivery of a digest to one sink of one subscriber. Entries are retried
+// until they are delivered or run out of attempts; the words are rescheduled once none of the
+// own entries of their digest, resends aside, is pending and at least one was delivered. File
+// is the export the entry keeps in the workspace; OmitFile leaves it out of the delivery, for
+// quiz subscribers whose answers it would show. A shared sink has one entry per digest, with
+// an empty Recipient, Quiz set when the digest is a quiz to any of its subscribers and no email
+// body. Resend marks the deliveries of a resend, which leave the words of the digest as they
+// are.
+type OutboxEntry struct {
+	ID            string       `bson:"_id" json:"id"`
+	DigestID      string       `bson:"digestId" json:"digest_id"`
+	Recipient     string       `bson:"recipient" json:"recipient"`
+	Sink          string       `bson:"sink" json:"sink"`
+	Subject       string       `bson:"subject" json:"subject"`
+	File          string       `bson:"file" json:"file"`
+	OmitFile      bool         `bson:"omitFile,omitempty" json:"omit_file,omitempty"`
+	Quiz          bool         `bson:"quiz,omitempty" json:"quiz,omitempty"`
+	Resend        bool         `bson:"resend,omitempty" json:"resend,omitempty"`
+	Count         int          `bson:"count" json:"count"`
+	HTML          string       `bson:"html" json:"-"`
+	Attachments   []Attachment `bson:"attachments,omitempty" json:"-"`
+	Status        string       `bson:"status" json:"status"`
+	Attempts      int          `bson:"attempts" json:"attempts"`
+	NextAttemptAt time.Time    `bson:"nextAttemptAt" json:"next_attempt_at"`
+	LastError     string       `bson:"lastError,omitempty" json:"last_error,omitempty"`
+	CreatedAt     time.Time    `bson:"createdAt" json:"created_at"`
+	DeliveredAt   time.Time    `bson:"deliveredAt,omitempty" json:"delivered_at,omitempty"`
+}
+
+// Attachment is a file rendered with the email body.
+type Attachment struct {
+	Name string `bson:"name"`
+	Data []byte `bson:"data"`
+}
+
+// Digest is the history record of a digest. The event IDs of its words are stored separately
+// in chunks, so that large digests stay below the document size limit. Deliveries and their
+// status are the outbox entries of the digest.
+type Digest struct {
+	ID         string    `bson:"_id" json:"id"`
+	CreatedAt  time.Time `bson:"createdAt" json:"created_at"`
+	Count      int       `bson:"count" json:"count"`
+	Recipients []string  `bson:"recipients" json:"recipients"`
+	Format     string    `bson:"format" json:"format"`
+	Formats    []string  `bson:"formats,omitempty" json:"formats,omitempty"`
+	Archive    string    `bson:"archive,omitempty" json:"archive,omitempty"`
 }
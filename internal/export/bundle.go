@@ -0,0 +1,250 @@
+package export
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+
+	ManifestName = "manifest.json"
+)
+
+var ErrUnknownArchive = errors.New("unknown archive format")
+
+// Manifest describes the contents of an archive.
+type Manifest struct {
+	BatchID   string         `json:"batch_id"`
+	CreatedAt time.Time      `json:"created_at"`
+	Count     int            `json:"count"`
+	Files     []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type bundlePart struct {
+	format   string
+	name     string
+	file     *os.File
+	exporter Exporter
+}
+
+// Bundle writes the words in several formats at once, each to a temporary file in dir, and
+// packs the files with a manifest into a ZIP or tar.gz archive when closed.
+type Bundle struct {
+	archive string
+	batchID string
+	w       io.Writer
+	parts   []bundlePart
+	count   int
+}
+
+// NewBundle returns a bundle of the formats writing the archive to w. An empty dir uses the
+// default directory for temporary files.
+func NewBundle(
+	archive string, formats []string, cfg config.Export, batchID, dir string, w io.Writer,
+) (*Bundle, error) {
+	const op = "export.NewBundle"
+
+	if _, err := ArchiveExtension(archive); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	b := &Bundle{archive: archive, batchID: batchID, w: w}
+	seen := make(map[string]bool, len(formats))
+	for _, format := range formats {
+		if seen[format] {
+			b.remove()
+			return nil, fmt.Errorf("%s: format %q listed twice", op, format)
+		}
+		seen[format] = true
+
+		file, err := os.CreateTemp(dir, "export-word-*."+Extension(format))
+		if err != nil {
+			b.remove()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		exporter, err := New(format, cfg, file)
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			b.remove()
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		b.parts = append(b.parts, bundlePart{
+			format:   format,
+			name:     fmt.Sprintf("words-%s.%s", format, Extension(format)),
+			file:     file,
+			exporter: exporter,
+		})
+	}
+	return b, nil
+}
+
+// Write writes the word in every format. A format that skips the word does not keep it from
+// the others; the skip is returned once all are written.
+func (b *Bundle) Write(word entity.MongoMessage) error {
+	var skipped error
+	for _, p := range b.parts {
+		if err := p.exporter.Write(word); errors.Is(err, ErrSkipped) {
+			skipped = err
+		} else if err != nil {
+			return err
+		}
+	}
+	b.count++
+	return skipped
+}
+
+// Close finishes every format, writes the archive and removes the temporary files.
+func (b *Bundle) Close() error {
+	const op = "export.Bundle.Close"
+	defer b.remove()
+
+	for _, p := range b.parts {
+		if err := p.exporter.Close(); err != nil {
+			return fmt.Errorf("%s: %s: %w", op, p.format, err)
+		}
+	}
+
+	var err error
+	if b.archive == ArchiveZip {
+		err = b.writeZip()
+	} else {
+		err = b.writeTarGz()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (b *Bundle) writeZip() error {
+	zw := zip.NewWriter(b.w)
+	manifest := b.manifest()
+	for _, p := range b.parts {
+		fw, err := zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		file, err := b.copyPart(fw, p)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	fw, err := zw.Create(ManifestName)
+	if err != nil {
+		return err
+	}
+	if err := writeManifest(fw, manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (b *Bundle) writeTarGz() error {
+	gw := gzip.NewWriter(b.w)
+	tw := tar.NewWriter(gw)
+	manifest := b.manifest()
+	for _, p := range b.parts {
+		info, err := p.file.Stat()
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name: p.name, Mode: 0644, Size: info.Size(), ModTime: manifest.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		file, err := b.copyPart(tw, p)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	var data bytes.Buffer
+	if err := writeManifest(&data, manifest); err != nil {
+		return err
+	}
+	err := tw.WriteHeader(&tar.Header{
+		Name: ManifestName, Mode: 0644, Size: int64(data.Len()), ModTime: manifest.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := data.WriteTo(tw); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func (b *Bundle) manifest() Manifest {
+	return Manifest{BatchID: b.batchID, CreatedAt: time.Now().UTC(), Count: b.count}
+}
+
+// copyPart copies the temporary file of the part to w, hashing it on the way.
+func (b *Bundle) copyPart(w io.Writer, p bundlePart) (ManifestFile, error) {
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return ManifestFile{}, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, hash), p.file)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	return ManifestFile{
+		Name:   p.name,
+		Format: p.format,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+func (b *Bundle) remove() {
+	for _, p := range b.parts {
+		p.file.Close()
+		os.Remove(p.file.Name())
+	}
+	b.parts = nil
+}
+
+func writeManifest(w io.Writer, manifest Manifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(manifest)
+}
+
+// ArchiveExtension returns the file extension of the archive format.
+func ArchiveExtension(archive string) (string, error) {
+	switch archive {
+	case ArchiveZip, ArchiveTarGz:
+		return archive, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownArchive, archive)
+	}
+}
@@ -0,0 +1,92 @@
+package export
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"io"
+)
+
+const (
+	FormatCSV       = "csv"
+	FormatPDF       = "pdf"
+	FormatXLSX      = "xlsx"
+	FormatQuizlet   = "quizlet"
+	FormatMemrise   = "memrise"
+	FormatMnemosyne = "mnemosyne"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown export format")
+	// ErrSkipped is returned by Write for a word the format cannot show. The word is left out,
+	// the export stays valid and the following words are written as usual.
+	ErrSkipped = errors.New("word skipped")
+)
+
+// Exporter receives the words of a digest one at a time and writes them in its format.
+// Close flushes buffered output; it does not close the underlying writer.
+type Exporter interface {
+	Write(word entity.MongoMessage) error
+	Close() error
+}
+
+// New returns the exporter for the format writing to w.
+func New(format string, cfg config.Export, w io.Writer) (Exporter, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSV(w), nil
+	case FormatPDF:
+		return NewPDF(cfg.PDF, w)
+	case FormatXLSX:
+		return NewXLSX(w)
+	case FormatQuizlet:
+		return NewQuizlet(cfg.Quizlet, w)
+	case FormatMemrise:
+		return NewMemrise(cfg.Memrise, w)
+	case FormatMnemosyne:
+		return NewMnemosyne(cfg.Mnemosyne, w), nil
+	default:
+		return nil, fmt.Errorf("export.New: %w: %q", ErrUnknownFormat, format)
+	}
+}
+
+// Extension returns the file extension of the format.
+func Extension(format string) string {
+	switch format {
+	case FormatCSV, FormatQuizlet:
+		return "txt"
+	case FormatMemrise:
+		return "csv"
+	case FormatMnemosyne:
+		return "xml"
+	default:
+		return format
+	}
+}
+
+// CSV writes "word;translation" lines, the format Anki imports as plain text.
+type CSV struct {
+	w *bufio.Writer
+}
+
+func NewCSV(w io.Writer) *CSV {
+	return &CSV{w: bufio.NewWriter(w)}
+}
+
+func (c *CSV) Write(word entity.MongoMessage) error {
+	const op = "export.CSV.Write"
+	if _, err := fmt.Fprintf(c.w, "%s;%s\n", word.Word, word.Translation); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (c *CSV) Close() error {
+	const op = "export.CSV.Close"
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
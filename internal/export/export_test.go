@@ -0,0 +1,245 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// importWords are the cards of the import samples in testdata. They carry what the formats
+// have to escape or cannot carry: delimiters, quotes, tabs and line breaks inside fields and
+// markup characters.
+var importWords = []entity.MongoMessage{
+	{
+		EventID:     uuid.MustParse("7d444840-9dc0-11d1-b245-5ffdce74fad2"),
+		Word:        "apple",
+		Translation: "яблоко",
+		Example:     "An apple a day",
+		Tags:        []string{"food", "fruit"},
+		CreatedAt:   time.Date(2026, 1, 2, 15, 4, 0, 0, time.UTC),
+	},
+	{
+		EventID:     uuid.MustParse("3c5e1a2b-8d4f-4a6e-9b7c-1d2e3f4a5b6c"),
+		Word:        "salt, pepper",
+		Translation: "соль; перец",
+		Tags:        []string{"food"},
+	},
+	{
+		EventID:     uuid.MustParse("9b2f1a6e-3c4d-4e5f-8a7b-0c1d2e3f4a5b"),
+		Word:        `to say "cheese"`,
+		Translation: "улыбаться\tна фото",
+		Example:     `She said "cheese", twice`,
+	},
+	{
+		EventID:     uuid.MustParse("5a6b7c8d-9e0f-4a1b-8c2d-3e4f5a6b7c8d"),
+		Word:        "to look\nup",
+		Translation: "искать\r\nв словаре",
+	},
+	{
+		EventID:     uuid.MustParse("0f8e7d6c-5b4a-4938-a271-605f4e3d2c1b"),
+		Word:        "Q&A <b>bold</b>",
+		Translation: "вопросы & ответы > всё",
+	},
+}
+
+// TestImportSamples exports importWords and reads the output the way the importer of each
+// application does, expecting the same cards as its sample in testdata. The samples are
+// written by hand after the documented import formats, not by the exporters: a Quizlet paste
+// with the default and with custom separators, a CSV after the Memrise bulk import template
+// and a Mnemosyne 1.x XML export.
+func TestImportSamples(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		cfg    config.Export
+		sample string
+		read   func(t *testing.T, data []byte) [][]string
+	}{
+		{
+			name:   "quizlet",
+			format: FormatQuizlet,
+			cfg: config.Export{
+				Quizlet: config.Quizlet{TermSeparator: "tab", RowSeparator: "newline"},
+			},
+			sample: "quizlet-import.txt",
+			read:   quizletReader("\t", "\n"),
+		},
+		{
+			name:   "quizlet custom separators",
+			format: FormatQuizlet,
+			cfg: config.Export{
+				Quizlet: config.Quizlet{TermSeparator: "comma", RowSeparator: "semicolon"},
+			},
+			sample: "quizlet-import-custom.txt",
+			read:   quizletReader(",", ";"),
+		},
+		{
+			name:   "memrise",
+			format: FormatMemrise,
+			cfg:    config.Export{Memrise: config.Memrise{Delimiter: "comma", LevelBy: "tag"}},
+			sample: "memrise-import.csv",
+			read:   readMemrise,
+		},
+		{
+			name:   "mnemosyne",
+			format: FormatMnemosyne,
+			cfg:    config.Export{Mnemosyne: config.Mnemosyne{Category: "export-word"}},
+			sample: "mnemosyne1-export.xml",
+			read:   readMnemosyne,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sample, err := os.ReadFile(filepath.Join("testdata", tt.sample))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.read(t, sample)
+			if len(want) < len(importWords) {
+				t.Fatalf("sample has %d cards, want %d", len(want), len(importWords))
+			}
+
+			var buf bytes.Buffer
+			exporter, err := New(tt.format, tt.cfg, &buf)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			for _, w := range importWords {
+				if err := exporter.Write(w); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			got := tt.read(t, buf.Bytes())
+			if len(got) != len(want) {
+				t.Fatalf("%d cards, want %d:\n%s", len(got), len(want), buf.Bytes())
+			}
+			for i := range want {
+				if !slices.Equal(got[i], want[i]) {
+					t.Errorf("card %d = %q, want %q", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+// quizletReader splits the text into rows and terms as Quizlet's import dialog does with the
+// separators, which it reads literally.
+func quizletReader(term, row string) func(t *testing.T, data []byte) [][]string {
+	return func(t *testing.T, data []byte) [][]string {
+		t.Helper()
+		var cards [][]string
+		for _, r := range strings.Split(string(data), row) {
+			if r == "" {
+				continue
+			}
+			fields := strings.Split(r, term)
+			if len(fields) != 2 {
+				t.Fatalf("row %q has %d fields, want a term and a definition", r, len(fields))
+			}
+			cards = append(cards, fields)
+		}
+		return cards
+	}
+}
+
+// readMemrise reads the CSV as RFC 4180, by the column names of the header row.
+func readMemrise(t *testing.T, data []byte) [][]string {
+	t.Helper()
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	if len(records) == 0 {
+		t.Fatal("no header row")
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	var cards [][]string
+	for _, record := range records[1:] {
+		card := make([]string, 0, len(memriseHeader))
+		for _, name := range memriseHeader {
+			i, ok := columns[name]
+			if !ok {
+				t.Fatalf("no %s column in %q", name, records[0])
+			}
+			card = append(card, record[i])
+		}
+		cards = append(cards, card)
+	}
+	return cards
+}
+
+// readMnemosyne reads the XML the way Mnemosyne 2 imports a 1.x file: the root names the core
+// version, the categories are read apart from the items, and every item is a card with its
+// ID, category, unseen flag, question and answer. The category names are appended as a last
+// card, sorted, as their position does not matter.
+func readMnemosyne(t *testing.T, data []byte) [][]string {
+	t.Helper()
+	var doc struct {
+		XMLName     xml.Name `xml:"mnemosyne"`
+		CoreVersion string   `xml:"core_version,attr"`
+		Categories  []struct {
+			Active string `xml:"active,attr"`
+			Name   string `xml:"name"`
+		} `xml:"category"`
+		Items []struct {
+			ID     string `xml:"id,attr"`
+			Cat    string `xml:"cat,attr"`
+			Unseen string `xml:"u,attr"`
+			Q      string `xml:"Q"`
+			A      string `xml:"A"`
+		} `xml:"item"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("xml: %v", err)
+	}
+	if doc.CoreVersion != "1" {
+		t.Fatalf("core_version = %q, want 1", doc.CoreVersion)
+	}
+	var cards [][]string
+	for _, item := range doc.Items {
+		cards = append(cards, []string{item.ID, item.Cat, item.Unseen, item.Q, item.A})
+	}
+	var names []string
+	for _, cat := range doc.Categories {
+		if cat.Active != "1" {
+			t.Errorf("category %s is not active", cat.Name)
+		}
+		names = append(names, cat.Name)
+	}
+	slices.Sort(names)
+	return append(cards, names)
+}
+
+func TestMnemosyneInvalidCharacters(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewMnemosyne(config.Mnemosyne{Category: "export-word"}, &buf)
+	err := m.Write(entity.MongoMessage{Word: "bell\x07", Translation: "колокол\x01ьчик"})
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	cards := readMnemosyne(t, buf.Bytes())
+	// The characters XML cannot carry are replaced, the rest of the text is kept.
+	if q, a := cards[0][3], cards[0][4]; q != "bell\uFFFD" || a != "колокол\uFFFDьчик" {
+		t.Errorf("card = %q, %q", q, a)
+	}
+}
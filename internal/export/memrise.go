@@ -0,0 +1,92 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	LevelByTag      = "tag"
+	LevelByLanguage = "language"
+	LevelByDate     = "date"
+
+	defaultLevel = "General"
+)
+
+var memriseHeader = []string{"Level", "Word", "Definition", "Example", "Tags"}
+
+// Memrise writes a CSV with a level column for Memrise's bulk import. Fields are quoted
+// following RFC 4180, which Memrise's importer understands.
+type Memrise struct {
+	w       *csv.Writer
+	levelBy string
+	header  bool
+}
+
+func NewMemrise(cfg config.Memrise, w io.Writer) (*Memrise, error) {
+	const op = "export.NewMemrise"
+
+	delim := separator(cfg.Delimiter)
+	r, size := utf8.DecodeRuneInString(delim)
+	if size == 0 || size != len(delim) || r == '"' || r == '\r' || r == '\n' {
+		return nil, fmt.Errorf("%s: delimiter must be a single character other than a quote", op)
+	}
+	switch cfg.LevelBy {
+	case LevelByTag, LevelByLanguage, LevelByDate:
+	default:
+		return nil, fmt.Errorf("%s: unknown level_by %q", op, cfg.LevelBy)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = r
+	return &Memrise{w: cw, levelBy: cfg.LevelBy}, nil
+}
+
+func (m *Memrise) Write(word entity.MongoMessage) error {
+	const op = "export.Memrise.Write"
+
+	if !m.header {
+		m.header = true
+		if err := m.w.Write(memriseHeader); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	record := []string{
+		m.level(word), word.Word, word.Translation, word.Example, strings.Join(word.Tags, " "),
+	}
+	if err := m.w.Write(record); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (m *Memrise) Close() error {
+	const op = "export.Memrise.Close"
+	m.w.Flush()
+	if err := m.w.Error(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (m *Memrise) level(word entity.MongoMessage) string {
+	switch m.levelBy {
+	case LevelByLanguage:
+		return languagePair(word)
+	case LevelByDate:
+		if !word.CreatedAt.IsZero() {
+			return word.CreatedAt.Format("2006-01-02")
+		}
+	default:
+		if len(word.Tags) > 0 {
+			return word.Tags[0]
+		}
+	}
+	return defaultLevel
+}
@@ -0,0 +1,93 @@
+package export
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"io"
+	"strings"
+	"time"
+)
+
+var newlines = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// Mnemosyne writes the Mnemosyne 1.x XML format, which Mnemosyne 2 imports as "Mnemosyne 1.x
+// XML". Every word becomes a new, unseen card in the category of its first tag. Text is
+// escaped with encoding/xml, which also replaces characters XML cannot carry; line breaks are
+// written as newlines, as an XML parser reads a literal one.
+type Mnemosyne struct {
+	w          *bufio.Writer
+	category   string
+	categories []string
+	seen       map[string]bool
+	started    bool
+}
+
+func NewMnemosyne(cfg config.Mnemosyne, w io.Writer) *Mnemosyne {
+	return &Mnemosyne{w: bufio.NewWriter(w), category: cfg.Category, seen: make(map[string]bool)}
+}
+
+func (m *Mnemosyne) Write(word entity.MongoMessage) error {
+	const op = "export.Mnemosyne.Write"
+
+	if err := m.start(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	cat := m.category
+	if len(word.Tags) > 0 {
+		cat = word.Tags[0]
+	}
+	if !m.seen[cat] {
+		m.seen[cat] = true
+		m.categories = append(m.categories, cat)
+	}
+
+	m.w.WriteString(`<item id="`)
+	xml.EscapeText(m.w, []byte(word.EventID.String()))
+	m.w.WriteString(`" cat="`)
+	xml.EscapeText(m.w, []byte(cat))
+	m.w.WriteString(`" u="1" gr="0" e="2.5" ac_rp="0" rt_rp="0" lps="0"`)
+	m.w.WriteString(` ac_rp_l="0" rt_rp_l="0" l_rp="0" n_rp="0">` + "\n<Q>")
+	xml.EscapeText(m.w, []byte(newlines.Replace(word.Word)))
+	m.w.WriteString("</Q>\n<A>")
+	xml.EscapeText(m.w, []byte(newlines.Replace(word.Translation)))
+	if _, err := m.w.WriteString("</A>\n</item>\n"); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// Close writes the categories, which Mnemosyne reads independently of their position, and
+// closes the document.
+func (m *Mnemosyne) Close() error {
+	const op = "export.Mnemosyne.Close"
+
+	if err := m.start(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	for _, cat := range m.categories {
+		m.w.WriteString(`<category active="1">` + "\n<name>")
+		xml.EscapeText(m.w, []byte(cat))
+		m.w.WriteString("</name>\n</category>\n")
+	}
+	m.w.WriteString("</mnemosyne>\n")
+	if err := m.w.Flush(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (m *Mnemosyne) start() error {
+	if m.started {
+		return nil
+	}
+	m.started = true
+	_, err := fmt.Fprintf(
+		m.w, "%s<mnemosyne core_version=\"1\" time_of_start=\"%d\">\n",
+		xml.Header, time.Now().Unix(),
+	)
+	return err
+}
@@ -0,0 +1,231 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font/gofont/goregular"
+	"io"
+	"strings"
+	"unicode"
+)
+
+const (
+	pdfMargin      = 10.0
+	pdfMaxFontSize = 22.0
+	pdfMinFontSize = 7.0
+	pdfPadding     = 4.0
+	pdfLineHeight  = 1.25
+
+	fontText = "text"
+	fontCJK  = "cjk"
+)
+
+// ErrNoCJKFont is returned, along with ErrSkipped, for a word with Chinese, Japanese or Korean
+// text when neither a CJK font nor a font of one's own is configured: the embedded Go font has
+// no glyphs for it and the card would show empty boxes, so the word gets no card.
+var ErrNoCJKFont = errors.New("cjk text needs a cjk_font")
+
+// ErrCardLimit is returned, along with ErrSkipped, for the words past the configured number of
+// cards.
+var ErrCardLimit = errors.New("pdf card limit reached")
+
+// PDF lays the words out as cut-out flashcards. Every page of words is followed by a page of
+// translations mirrored left to right, so that printing duplex and flipping on the long edge
+// puts each translation on the back of its word. The document is assembled in memory until
+// Close, so the number of cards is capped; the words past the cap get no card.
+type PDF struct {
+	pdf   *fpdf.Fpdf
+	w     io.Writer
+	cols  int
+	rows  int
+	cardW float64
+	cardH float64
+	// cards counts the words written, up to maxCards.
+	cards    int
+	maxCards int
+	hasCJK   bool
+	// goFont reports whether the text font is the embedded Go font.
+	goFont  bool
+	pending []entity.MongoMessage
+}
+
+func NewPDF(cfg config.PDF, w io.Writer) (*PDF, error) {
+	const op = "export.NewPDF"
+
+	if cfg.Columns <= 0 || cfg.Rows <= 0 {
+		return nil, fmt.Errorf("%s: grid must have at least one column and row", op)
+	}
+	if cfg.MaxCards <= 0 {
+		return nil, fmt.Errorf("%s: max_cards must be positive", op)
+	}
+
+	pdf := fpdf.New("P", "mm", cfg.PageSize, "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(false, 0)
+
+	if cfg.Font != "" {
+		pdf.AddUTF8Font(fontText, "", cfg.Font)
+	} else {
+		pdf.AddUTF8FontFromBytes(fontText, "", goregular.TTF)
+	}
+	if cfg.CJKFont != "" {
+		pdf.AddUTF8Font(fontCJK, "", cfg.CJKFont)
+	}
+	if pdf.Err() {
+		return nil, fmt.Errorf("%s: %w", op, pdf.Error())
+	}
+
+	pageW, pageH := pdf.GetPageSize()
+	return &PDF{
+		pdf:      pdf,
+		w:        w,
+		cols:     cfg.Columns,
+		rows:     cfg.Rows,
+		cardW:    (pageW - 2*pdfMargin) / float64(cfg.Columns),
+		cardH:    (pageH - 2*pdfMargin) / float64(cfg.Rows),
+		maxCards: cfg.MaxCards,
+		hasCJK:   cfg.CJKFont != "",
+		goFont:   cfg.Font == "",
+	}, nil
+}
+
+func (p *PDF) Write(word entity.MongoMessage) error {
+	const op = "export.PDF.Write"
+
+	if p.cards == p.maxCards {
+		return fmt.Errorf("%s: %q: %w: %w", op, word.Word, ErrSkipped, ErrCardLimit)
+	}
+	if !p.hasCJK && p.goFont && (containsCJK(word.Word) || containsCJK(word.Translation)) {
+		return fmt.Errorf("%s: %q: %w: %w", op, word.Word, ErrSkipped, ErrNoCJKFont)
+	}
+	p.cards++
+	p.pending = append(p.pending, word)
+	if len(p.pending) == p.cols*p.rows {
+		return p.flushSheet()
+	}
+	return nil
+}
+
+func (p *PDF) Close() error {
+	const op = "export.PDF.Close"
+
+	if len(p.pending) > 0 {
+		if err := p.flushSheet(); err != nil {
+			return err
+		}
+	}
+	if p.pdf.PageNo() == 0 {
+		p.pdf.AddPage()
+	}
+	if err := p.pdf.Output(p.w); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// flushSheet renders the pending cards as a front page and its mirrored back page.
+func (p *PDF) flushSheet() error {
+	const op = "export.PDF.flushSheet"
+
+	p.pdf.AddPage()
+	for i, word := range p.pending {
+		p.card(i%p.cols, i/p.cols, word.Word)
+	}
+	p.pdf.AddPage()
+	for i, word := range p.pending {
+		p.card(p.cols-1-i%p.cols, i/p.cols, word.Translation)
+	}
+	p.pending = p.pending[:0]
+
+	if p.pdf.Err() {
+		return fmt.Errorf("%s: %w", op, p.pdf.Error())
+	}
+	return nil
+}
+
+// card draws the dashed cut lines of the cell and centres the text in it, shrinking the font
+// and wrapping lines until it fits.
+func (p *PDF) card(col, row int, text string) {
+	x := pdfMargin + float64(col)*p.cardW
+	y := pdfMargin + float64(row)*p.cardH
+
+	p.pdf.SetDrawColor(170, 170, 170)
+	p.pdf.SetLineWidth(0.2)
+	p.pdf.SetDashPattern([]float64{2, 2}, 0)
+	p.pdf.Rect(x, y, p.cardW, p.cardH, "D")
+	p.pdf.SetDashPattern(nil, 0)
+
+	family := fontText
+	if p.hasCJK && containsCJK(text) {
+		family = fontCJK
+	}
+
+	maxW := p.cardW - 2*pdfPadding
+	maxH := p.cardH - 2*pdfPadding
+	var (
+		lines []string
+		lineH float64
+	)
+	for size := pdfMaxFontSize; size >= pdfMinFontSize; size-- {
+		p.pdf.SetFont(family, "", size)
+		_, unit := p.pdf.GetFontSize()
+		lineH = unit * pdfLineHeight
+		lines = p.wrap(text, maxW)
+		if float64(len(lines))*lineH <= maxH {
+			break
+		}
+	}
+
+	top := y + (p.cardH-float64(len(lines))*lineH)/2
+	for i, line := range lines {
+		lineW := p.pdf.GetStringWidth(line)
+		baseline := top + float64(i)*lineH + lineH*0.75
+		p.pdf.Text(x+(p.cardW-lineW)/2, baseline, line)
+	}
+}
+
+// wrap splits text into lines no wider than maxW, breaking between words where possible and
+// between characters otherwise, as CJK text has no spaces.
+func (p *PDF) wrap(text string, maxW float64) []string {
+	var (
+		lines []string
+		cur   string
+	)
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if cur != "" {
+			candidate = cur + " " + word
+		}
+		if p.pdf.GetStringWidth(candidate) <= maxW {
+			cur = candidate
+			continue
+		}
+		if cur != "" {
+			lines = append(lines, cur)
+			cur = ""
+		}
+		for _, r := range word {
+			if cur != "" && p.pdf.GetStringWidth(cur+string(r)) > maxW {
+				lines = append(lines, cur)
+				cur = ""
+			}
+			cur += string(r)
+		}
+	}
+	if cur != "" {
+		lines = append(lines, cur)
+	}
+	return lines
+}
+
+func containsCJK(s string) bool {
+	for _, r := range s {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"testing"
+)
+
+func TestPDFSkipsCJKWithoutFont(t *testing.T) {
+	tests := []struct {
+		name    string
+		word    entity.MongoMessage
+		skipped bool
+	}{
+		{name: "cyrillic", word: entity.MongoMessage{Word: "apple", Translation: "яблоко"}},
+		{
+			name:    "chinese translation",
+			word:    entity.MongoMessage{Word: "apple", Translation: "苹果"},
+			skipped: true,
+		},
+		{
+			name:    "japanese word",
+			word:    entity.MongoMessage{Word: "りんご", Translation: "apple"},
+			skipped: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			p, err := NewPDF(config.PDF{PageSize: "A4", Columns: 2, Rows: 4, MaxCards: 100}, &buf)
+			if err != nil {
+				t.Fatalf("NewPDF: %v", err)
+			}
+			err = p.Write(tt.word)
+			if tt.skipped {
+				if !errors.Is(err, ErrSkipped) || !errors.Is(err, ErrNoCJKFont) {
+					t.Fatalf("Write = %v, want ErrSkipped and ErrNoCJKFont", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			// The next word gets its card either way.
+			if err := p.Write(entity.MongoMessage{Word: "house", Translation: "дом"}); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if err := p.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")) {
+				t.Error("output is not a PDF")
+			}
+			if got := p.pdf.PageNo(); got != 2 {
+				t.Errorf("%d pages, want a front and a back page", got)
+			}
+		})
+	}
+}
+
+func TestPDFCardLimit(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewPDF(config.PDF{PageSize: "A4", Columns: 2, Rows: 2, MaxCards: 5}, &buf)
+	if err != nil {
+		t.Fatalf("NewPDF: %v", err)
+	}
+	for i := range 7 {
+		err := p.Write(entity.MongoMessage{Word: "apple", Translation: "яблоко"})
+		if i < 5 && err != nil {
+			t.Fatalf("Write %d: %v", i, err)
+		}
+		if i >= 5 && (!errors.Is(err, ErrSkipped) || !errors.Is(err, ErrCardLimit)) {
+			t.Fatalf("Write %d = %v, want ErrSkipped and ErrCardLimit", i, err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// Two sheets of four cards and one of a single card, each with a back page.
+	if got := p.pdf.PageNo(); got != 4 {
+		t.Errorf("%d pages, want 4", got)
+	}
+}
@@ -0,0 +1,74 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"io"
+	"strings"
+)
+
+// Quizlet writes term/definition rows for Quizlet's import dialog. Quizlet has no escape
+// syntax, so separators and line breaks inside a field are replaced with spaces.
+type Quizlet struct {
+	w        *bufio.Writer
+	term     string
+	row      string
+	replacer *strings.Replacer
+}
+
+func NewQuizlet(cfg config.Quizlet, w io.Writer) (*Quizlet, error) {
+	const op = "export.NewQuizlet"
+
+	term, row := separator(cfg.TermSeparator), separator(cfg.RowSeparator)
+	if term == "" || row == "" || term == row {
+		return nil, fmt.Errorf("%s: term and row separators must be set and differ", op)
+	}
+
+	return &Quizlet{
+		w:        bufio.NewWriter(w),
+		term:     term,
+		row:      row,
+		replacer: strings.NewReplacer(term, " ", row, " ", "\r\n", " ", "\n", " ", "\r", " "),
+	}, nil
+}
+
+func (q *Quizlet) Write(word entity.MongoMessage) error {
+	const op = "export.Quizlet.Write"
+	_, err := fmt.Fprintf(
+		q.w, "%s%s%s%s", q.field(word.Word), q.term, q.field(word.Translation), q.row,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (q *Quizlet) Close() error {
+	const op = "export.Quizlet.Close"
+	if err := q.w.Flush(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (q *Quizlet) field(s string) string {
+	return strings.Join(strings.Fields(q.replacer.Replace(s)), " ")
+}
+
+// separator resolves the names of common separators and returns anything else unchanged.
+func separator(name string) string {
+	switch strings.ToLower(name) {
+	case "tab":
+		return "\t"
+	case "comma":
+		return ","
+	case "semicolon":
+		return ";"
+	case "newline":
+		return "\n"
+	default:
+		return name
+	}
+}
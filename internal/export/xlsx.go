@@ -0,0 +1,284 @@
+package export
+
+import (
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	xlsxDefaultSheet = "Sheet1"
+	xlsxMaxSheetName = 31
+	xlsxMinWidth     = 10
+	xlsxMaxWidth     = 80
+	// xlsxWidthRows is the number of rows of a sheet held back to size its columns, which
+	// have to be set before the first row is streamed.
+	xlsxWidthRows   = 100
+	xlsxDateFormat  = "yyyy-mm-dd hh:mm"
+	unknownLanguage = "unknown"
+)
+
+var xlsxHeader = []string{"Word", "Translation", "Example", "Tags", "Created"}
+
+type xlsxSheet struct {
+	name string
+	// stream is nil while the first rows are held back in pending.
+	stream  *excelize.StreamWriter
+	pending [][]any
+	row     int
+	widths  []int
+	done    bool
+}
+
+// XLSX writes an Excel workbook with one worksheet per language pair. Every sheet has a bold,
+// frozen header row and columns sized to the longest value of its first rows. The rows are
+// streamed to temporary files, so a large export does not build up in memory; a pair with more
+// rows than a sheet holds continues on another sheet.
+type XLSX struct {
+	file   *excelize.File
+	w      io.Writer
+	sheets map[string]*xlsxSheet
+	order  []*xlsxSheet
+	// names holds the lower-cased sheet names, as Excel compares them case-insensitively.
+	names     map[string]bool
+	maxRows   int
+	dateStyle int
+	boldStyle int
+}
+
+func NewXLSX(w io.Writer) (*XLSX, error) {
+	const op = "export.NewXLSX"
+
+	file := excelize.NewFile()
+	dateStyle, err := file.NewStyle(&excelize.Style{CustomNumFmt: ptr(xlsxDateFormat)})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	boldStyle, err := file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &XLSX{
+		file:      file,
+		w:         w,
+		sheets:    make(map[string]*xlsxSheet),
+		names:     make(map[string]bool),
+		maxRows:   excelize.TotalRows,
+		dateStyle: dateStyle,
+		boldStyle: boldStyle,
+	}, nil
+}
+
+func (x *XLSX) Write(word entity.MongoMessage) error {
+	const op = "export.XLSX.Write"
+
+	sh, err := x.sheet(languagePair(word))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	values := []any{
+		text(word.Word), text(word.Translation), text(word.Example),
+		text(strings.Join(word.Tags, ", ")),
+	}
+	if !word.CreatedAt.IsZero() {
+		values = append(values, excelize.Cell{StyleID: x.dateStyle, Value: word.CreatedAt})
+	}
+	sh.row++
+	if sh.stream != nil {
+		if err := x.setRow(sh, sh.row, values); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		return nil
+	}
+
+	for i, v := range values {
+		switch v := v.(type) {
+		case string:
+			sh.widths[i] = max(sh.widths[i], utf8.RuneCountInString(v))
+		case excelize.Cell:
+			sh.widths[i] = max(sh.widths[i], len(xlsxDateFormat))
+		}
+	}
+	sh.pending = append(sh.pending, values)
+	if len(sh.pending) == xlsxWidthRows {
+		if err := x.start(sh); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return nil
+}
+
+func (x *XLSX) Close() error {
+	const op = "export.XLSX.Close"
+	defer x.file.Close()
+
+	for _, sh := range x.order {
+		if err := x.finish(sh); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if len(x.order) > 0 {
+		if err := x.file.DeleteSheet(xlsxDefaultSheet); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		x.file.SetActiveSheet(0)
+	}
+
+	if _, err := x.file.WriteTo(x.w); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// sheet returns the worksheet the next row of the language pair goes to, creating one when the
+// pair has none yet or its sheet is full.
+func (x *XLSX) sheet(pair string) (*xlsxSheet, error) {
+	sh, ok := x.sheets[pair]
+	if ok && sh.row < x.maxRows {
+		return sh, nil
+	}
+	if ok {
+		if err := x.finish(sh); err != nil {
+			return nil, err
+		}
+	}
+
+	sh = &xlsxSheet{
+		name:   x.sheetName(pair),
+		row:    1,
+		widths: make([]int, len(xlsxHeader)),
+	}
+	if _, err := x.file.NewSheet(sh.name); err != nil {
+		return nil, err
+	}
+	for i, h := range xlsxHeader {
+		sh.widths[i] = len(h)
+	}
+
+	x.sheets[pair] = sh
+	x.names[strings.ToLower(sh.name)] = true
+	x.order = append(x.order, sh)
+	return sh, nil
+}
+
+// start sizes the columns of the sheet to the rows held back, opens its stream and writes the
+// header and those rows.
+func (x *XLSX) start(sh *xlsxSheet) error {
+	stream, err := x.file.NewStreamWriter(sh.name)
+	if err != nil {
+		return err
+	}
+	for i, width := range sh.widths {
+		w := float64(min(xlsxMaxWidth, max(xlsxMinWidth, width+2)))
+		if err := stream.SetColWidth(i+1, i+1, w); err != nil {
+			return err
+		}
+	}
+	err = stream.SetPanes(&excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	})
+	if err != nil {
+		return err
+	}
+	sh.stream = stream
+
+	header := make([]any, 0, len(xlsxHeader))
+	for _, h := range xlsxHeader {
+		header = append(header, excelize.Cell{StyleID: x.boldStyle, Value: h})
+	}
+	if err := x.setRow(sh, 1, header); err != nil {
+		return err
+	}
+	for i, values := range sh.pending {
+		if err := x.setRow(sh, i+2, values); err != nil {
+			return err
+		}
+	}
+	sh.pending = nil
+	return nil
+}
+
+// finish writes the rows still held back and ends the stream of the sheet, once.
+func (x *XLSX) finish(sh *xlsxSheet) error {
+	if sh.done {
+		return nil
+	}
+	if sh.stream == nil {
+		if err := x.start(sh); err != nil {
+			return err
+		}
+	}
+	sh.done = true
+	return sh.stream.Flush()
+}
+
+func (x *XLSX) setRow(sh *xlsxSheet, row int, values []any) error {
+	cell, err := excelize.CoordinatesToCellName(1, row)
+	if err != nil {
+		return err
+	}
+	return sh.stream.SetRow(cell, values)
+}
+
+// languagePair returns "from-to", using "unknown" for a missing side.
+func languagePair(word entity.MongoMessage) string {
+	from, to := word.LangFrom, word.LangTo
+	if from == "" {
+		from = unknownLanguage
+	}
+	if to == "" {
+		to = unknownLanguage
+	}
+	return from + "-" + to
+}
+
+// sheetName strips the characters Excel does not allow in sheet names from the pair. A name
+// that is too long, or taken by another pair that sanitizes or truncates to the same one, gets
+// a numeric suffix, starting at the sheet index, that makes it unique.
+func (x *XLSX) sheetName(pair string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, pair)
+	if utf8.RuneCountInString(name) <= xlsxMaxSheetName && !x.taken(name) {
+		return name
+	}
+	for n := len(x.order); ; n++ {
+		suffix := fmt.Sprintf("~%d", n)
+		base := []rune(name)
+		if len(base) > xlsxMaxSheetName-len(suffix) {
+			base = base[:xlsxMaxSheetName-len(suffix)]
+		}
+		if candidate := string(base) + suffix; !x.taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// taken reports whether a sheet of the name exists, including the default sheet that is
+// removed on Close.
+func (x *XLSX) taken(name string) bool {
+	return strings.EqualFold(name, xlsxDefaultSheet) || x.names[strings.ToLower(name)]
+}
+
+// text returns the value of a text cell, nil for an empty one, which is left out of the sheet.
+func text(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,135 @@
+package export
+
+import (
+	"bytes"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/xuri/excelize/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestXLSXSheetNames(t *testing.T) {
+	long := strings.Repeat("x", 40)
+	words := []entity.MongoMessage{
+		{Word: "apple", Translation: "яблоко", LangFrom: "en", LangTo: "r:u"},
+		{Word: "house", Translation: "дом", LangFrom: "en", LangTo: "r/u"},
+		{Word: "tree", Translation: "дерево", LangFrom: "EN", LangTo: "R_U"},
+		{Word: "cat", Translation: "кошка", LangFrom: "en", LangTo: "r:u"},
+		{Word: "dog", Translation: "собака", LangFrom: long, LangTo: "a"},
+		{Word: "sun", Translation: "солнце", LangFrom: long, LangTo: "b"},
+		{Word: "one", Translation: "один", LangFrom: "sheet1"},
+	}
+
+	var buf bytes.Buffer
+	x, err := NewXLSX(&buf)
+	if err != nil {
+		t.Fatalf("NewXLSX: %v", err)
+	}
+	for _, w := range words {
+		if err := x.Write(w); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := x.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer f.Close()
+
+	want := []string{
+		"en-r_u", "en-r_u~1", "EN-R_U~2",
+		strings.Repeat("x", 29) + "~3", strings.Repeat("x", 29) + "~4",
+		"sheet1-unknown",
+	}
+	if got := f.GetSheetList(); !slices.Equal(got, want) {
+		t.Fatalf("sheets = %q, want %q", got, want)
+	}
+	rows, err := f.GetRows("en-r_u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header and the two words of the pair "en-r:u".
+	if len(rows) != 3 || rows[1][0] != "apple" || rows[2][0] != "cat" {
+		t.Errorf("rows of en-r_u = %q", rows)
+	}
+}
+
+func TestXLSXSplitsFullSheets(t *testing.T) {
+	var buf bytes.Buffer
+	x, err := NewXLSX(&buf)
+	if err != nil {
+		t.Fatalf("NewXLSX: %v", err)
+	}
+	// A sheet of the header and 150 words, more than are held back to size the columns.
+	x.maxRows = 151
+	long := strings.Repeat("a", 50)
+	for i := range 200 {
+		w := entity.MongoMessage{Word: strconv.Itoa(i), Translation: "слово", LangFrom: "en"}
+		if i == 120 {
+			// Past the rows that size the columns, so it does not widen the column.
+			w.Translation = long
+		}
+		if err := x.Write(w); err != nil {
+			t.Fatalf("Write %d: %v", i, err)
+		}
+	}
+	if err := x.Write(entity.MongoMessage{Word: "apple", LangFrom: "de"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := x.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("OpenReader: %v", err)
+	}
+	defer f.Close()
+
+	want := []string{"en-unknown", "en-unknown~1", "de-unknown"}
+	if got := f.GetSheetList(); !slices.Equal(got, want) {
+		t.Fatalf("sheets = %q, want %q", got, want)
+	}
+	first, err := f.GetRows("en-unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := f.GetRows("en-unknown~1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 151 || len(second) != 51 {
+		t.Fatalf("rows = %d and %d, want 151 and 51", len(first), len(second))
+	}
+	for _, rows := range [][][]string{first, second} {
+		if !slices.Equal(rows[0], xlsxHeader[:len(rows[0])]) {
+			t.Errorf("header = %q", rows[0])
+		}
+	}
+	if first[1][0] != "0" || first[150][0] != "149" || second[1][0] != "150" {
+		t.Errorf("words out of order: %q, %q, %q", first[1], first[150], second[1])
+	}
+	if first[121][1] != long {
+		t.Errorf("row 121 = %q", first[121])
+	}
+	width, err := f.GetColWidth("en-unknown", "B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := float64(len("Translation") + 2); width != want {
+		t.Errorf("column B is %v wide, want %v", width, want)
+	}
+	panes, err := f.GetPanes("en-unknown~1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !panes.Freeze || panes.YSplit != 1 {
+		t.Errorf("header row of the second sheet not frozen: %+v", panes)
+	}
+}
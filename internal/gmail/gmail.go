@@ -1,9 +1,18 @@
 package gmail
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/dkim"
+	"github.com/fentezi/export-word/internal/pgp"
 	"gopkg.in/gomail.v2"
+	"io"
+	"net/url"
+	"strings"
+	"time"
 )
 
 type Message struct {
@@ -12,17 +21,75 @@ type Message struct {
 	Subject string
 	Body    string
 	File    string
+	// MessageID is the Message-ID header with its angle brackets, generated by the relay when
+	// empty. References lists the message IDs the message belongs to, for threading.
+	MessageID  string
+	References []string
+	// EncryptTo, when set, encrypts the body and the attachments to the keys with PGP/MIME.
+	EncryptTo   openpgp.EntityList
+	Attachments []Attachment
+	// Embeds are inline files referenced from the HTML body as "cid:" + Name.
+	Embeds []Attachment
+}
+
+// Attachment is an in-memory file attached in addition to File.
+type Attachment struct {
+	Name string
+	Data []byte
 }
 
 type Gmail struct {
 	dial *gomail.Dialer
+	// signer signs every message with DKIM when set.
+	signer      *dkim.Signer
+	unsubscribe string
+	oneClick    bool
 }
 
-func New(gm config.Gmail) Gmail {
-	dial := gomail.NewDialer("smtp.gmail.com", 587, gm.Email, gm.Password)
-	return Gmail{
-		dial: dial,
+// New returns a sender through the configured SMTP relay, smtp.gmail.com by default, logging
+// in with the Gmail credentials.
+func New(gm config.Gmail, mail config.Mail) (Gmail, error) {
+	const op = "gmail.New"
+
+	host, port := mail.Host, mail.Port
+	if host == "" {
+		host, port = "smtp.gmail.com", 587
 	}
+	g := Gmail{dial: gomail.NewDialer(host, port, gm.Email, gm.Password)}
+
+	if mail.DKIM.KeyFile != "" {
+		key, err := dkim.LoadKey(mail.DKIM.KeyFile)
+		if err != nil {
+			return Gmail{}, fmt.Errorf("%s: %w", op, err)
+		}
+		domain := mail.DKIM.Domain
+		if domain == "" {
+			domain = Domain(gm.Email)
+		}
+		g.signer, err = dkim.New(domain, mail.DKIM.Selector, key, mail.DKIM.Headers)
+		if err != nil {
+			return Gmail{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	var links []string
+	if mail.Unsubscribe.Mailto != "" {
+		links = append(links, "<mailto:"+mail.Unsubscribe.Mailto+"?subject=unsubscribe>")
+	}
+	if mail.Unsubscribe.URL != "" {
+		u, err := url.Parse(mail.Unsubscribe.URL)
+		if err != nil {
+			return Gmail{}, fmt.Errorf("%s: unsubscribe url: %w", op, err)
+		}
+		if mail.Unsubscribe.OneClick && u.Scheme != "https" {
+			return Gmail{}, fmt.Errorf("%s: one-click unsubscribe needs an https url", op)
+		}
+		links = append(links, "<"+u.String()+">")
+		g.oneClick = mail.Unsubscribe.OneClick
+	}
+	g.unsubscribe = strings.Join(links, ", ")
+
+	return g, nil
 }
 
 func (g *Gmail) SendMessage(message Message) error {
@@ -44,11 +111,74 @@ func (g *Gmail) SendMessage(message Message) error {
 	msg.SetHeader("To", message.To...)
 
 	msg.SetHeader("Subject", message.Subject)
+	if message.MessageID != "" {
+		msg.SetHeader("Message-ID", message.MessageID)
+	}
+	if len(message.References) > 0 {
+		msg.SetHeader("References", strings.Join(message.References, " "))
+	}
+	if g.unsubscribe != "" {
+		msg.SetHeader("List-Unsubscribe", g.unsubscribe)
+		if g.oneClick {
+			msg.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+		}
+	}
 	msg.SetBody("text/html", message.Body)
 
 	if message.File != "" {
 		msg.Attach(message.File)
 	}
+	for _, a := range message.Attachments {
+		msg.Attach(a.Name, copyData(a.Data))
+	}
+	for _, e := range message.Embeds {
+		msg.Embed(e.Name, copyData(e.Data))
+	}
+
+	if g.signer == nil && len(message.EncryptTo) == 0 {
+		return g.dial.DialAndSend(msg)
+	}
+
+	s, err := g.dial.Dial()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	// The message is rendered once and encrypted before it is signed, so that the signature
+	// covers exactly the bytes sent.
+	return gomail.Send(
+		gomail.SendFunc(func(from string, to []string, m io.WriterTo) error {
+			var buf bytes.Buffer
+			if _, err := m.WriteTo(&buf); err != nil {
+				return err
+			}
+			raw := buf.Bytes()
+			var err error
+			if len(message.EncryptTo) > 0 {
+				if raw, err = pgp.Encrypt(raw, message.EncryptTo); err != nil {
+					return err
+				}
+			}
+			if g.signer != nil {
+				if raw, err = g.signer.Sign(raw, time.Now()); err != nil {
+					return err
+				}
+			}
+			return s.Send(from, to, bytes.NewReader(raw))
+		}),
+		msg,
+	)
+}
+
+// Domain returns the domain of the email address.
+func Domain(address string) string {
+	_, domain, _ := strings.Cut(address, "@")
+	return strings.TrimSuffix(domain, ">")
+}
 
-	return g.dial.DialAndSend(msg)
+func copyData(data []byte) gomail.FileSetting {
+	return gomail.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
 }
@@ -0,0 +1,223 @@
+package gmail
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/smtptest"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sender = "words@example.com"
+
+func newTestGmail(t *testing.T, srv *smtptest.Server, mail config.Mail) Gmail {
+	t.Helper()
+	mail.Host, mail.Port = srv.Host, srv.Port
+	g, err := New(config.Gmail{Email: sender, Password: "secret"}, mail)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return g
+}
+
+func TestSendMessageValidation(t *testing.T) {
+	valid := Message{From: sender, To: []string{"a@example.com"}, Subject: "s", Body: "b"}
+	tests := []struct {
+		name   string
+		modify func(m *Message)
+		want   string
+	}{
+		{"no from", func(m *Message) { m.From = "" }, "from field is empty"},
+		{"no recipients", func(m *Message) { m.To = nil }, "to field is empty"},
+		{"no subject", func(m *Message) { m.Subject = "" }, "subject field is empty"},
+		{"no body", func(m *Message) { m.Body = "" }, "body field is empty"},
+	}
+
+	srv := smtptest.NewServer(t)
+	g := newTestGmail(t, srv, config.Mail{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := valid
+			tt.modify(&msg)
+			err := g.SendMessage(msg)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("SendMessage() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+	if n := len(srv.Messages()); n != 0 {
+		t.Errorf("%d messages sent for invalid input", n)
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	srv := smtptest.NewServer(t)
+	g := newTestGmail(t, srv, config.Mail{})
+
+	file := filepath.Join(t.TempDir(), "words-2026-01-02T15-04.csv")
+	if err := os.WriteFile(file, []byte("apple;яблоко\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := g.SendMessage(Message{
+		From:        sender,
+		To:          []string{"a@example.com", "b@example.com"},
+		Subject:     "Dictionary",
+		Body:        "<p>apple — яблоко</p>",
+		File:        file,
+		Attachments: []Attachment{{Name: "answers.txt", Data: []byte("1. apple\n")}},
+		Embeds:      []Attachment{{Name: "chart.png", Data: []byte("\x89PNG")}},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	msg := srv.WaitMessages(t, 1)[0]
+	if msg.From != sender {
+		t.Errorf("envelope sender = %q, want %q", msg.From, sender)
+	}
+	msg.AssertRecipients(t, "a@example.com", "b@example.com")
+	msg.AssertSubject(t, "Dictionary")
+	msg.AssertBodyContains(t, "text/html", "<p>apple — яблоко</p>")
+	msg.AssertFile(t, filepath.Base(file), []byte("apple;яблоко\n"))
+	msg.AssertFile(t, "answers.txt", []byte("1. apple\n"))
+	msg.AssertFile(t, "chart.png", []byte("\x89PNG"))
+	if got := msg.Header.Get("DKIM-Signature"); got != "" {
+		t.Errorf("unexpected DKIM-Signature %q", got)
+	}
+}
+
+func TestSendMessageHeaders(t *testing.T) {
+	srv := smtptest.NewServer(t)
+	g := newTestGmail(t, srv, config.Mail{Unsubscribe: config.Unsubscribe{
+		Mailto: "unsubscribe@example.com", URL: "https://example.com/unsubscribe", OneClick: true,
+	}})
+
+	err := g.SendMessage(Message{
+		From:       sender,
+		To:         []string{"a@example.com"},
+		Subject:    "Dictionary",
+		Body:       "<p>words</p>",
+		MessageID:  "<d1.abcd.1@example.com>",
+		References: []string{"<d1@example.com>"},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	msg := srv.WaitMessages(t, 1)[0]
+	msg.AssertHeader(t, "Message-ID", "<d1.abcd.1@example.com>")
+	msg.AssertHeader(t, "References", "<d1@example.com>")
+	msg.AssertHeader(
+		t, "List-Unsubscribe",
+		"<mailto:unsubscribe@example.com?subject=unsubscribe>, <https://example.com/unsubscribe>",
+	)
+	msg.AssertHeader(t, "List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+}
+
+func TestNewRejectsOneClickWithoutHTTPS(t *testing.T) {
+	_, err := New(config.Gmail{Email: sender}, config.Mail{Unsubscribe: config.Unsubscribe{
+		URL: "http://example.com/unsubscribe", OneClick: true,
+	}})
+	if err == nil {
+		t.Fatal("New() accepted a plain http one-click url")
+	}
+}
+
+func TestSendMessageDKIM(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "dkim.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(keyFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := smtptest.NewServer(t)
+	g := newTestGmail(t, srv, config.Mail{DKIM: config.DKIM{Selector: "mail", KeyFile: keyFile}})
+	err = g.SendMessage(Message{
+		From: sender, To: []string{"a@example.com"}, Subject: "Dictionary", Body: "<p>words</p>",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	msg := srv.WaitMessages(t, 1)[0]
+	sig := msg.Header.Get("DKIM-Signature")
+	for _, tag := range []string{"a=ed25519-sha256", "d=example.com", "s=mail", "h=from:to:subject"} {
+		if !strings.Contains(sig, tag) {
+			t.Errorf("DKIM-Signature %q lacks %s", sig, tag)
+		}
+	}
+	if !bytes.HasPrefix(msg.Raw, []byte("DKIM-Signature:")) {
+		t.Error("DKIM-Signature is not the first header field")
+	}
+	msg.AssertBodyContains(t, "text/html", "<p>words</p>")
+}
+
+func TestSendMessageEncrypted(t *testing.T) {
+	entity, err := openpgp.NewEntity("Reader", "", "a@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := smtptest.NewServer(t)
+	g := newTestGmail(t, srv, config.Mail{})
+	err = g.SendMessage(Message{
+		From:        sender,
+		To:          []string{"a@example.com"},
+		Subject:     "Dictionary",
+		Body:        "<p>confidential</p>",
+		Attachments: []Attachment{{Name: "answers.txt", Data: []byte("1. confidential\n")}},
+		EncryptTo:   openpgp.EntityList{entity},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	msg := srv.WaitMessages(t, 1)[0]
+	msg.AssertSubject(t, "Dictionary")
+	if bytes.Contains(msg.Raw, []byte("confidential")) {
+		t.Fatal("plaintext content in the encrypted message")
+	}
+	if !strings.HasPrefix(msg.Header.Get("Content-Type"), "multipart/encrypted;") {
+		t.Fatalf("Content-Type = %q", msg.Header.Get("Content-Type"))
+	}
+	part, ok := msg.File("encrypted.asc")
+	if !ok {
+		t.Fatalf("no encrypted part, files: %v", msg.Files())
+	}
+
+	block, err := armor.Decode(bytes.NewReader(part.Data))
+	if err != nil {
+		t.Fatalf("armor: %v", err)
+	}
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{entity}, nil, nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	plain, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	inner, err := smtptest.Parse(sender, msg.To, plain)
+	if err != nil {
+		t.Fatalf("parse decrypted part: %v", err)
+	}
+	inner.AssertBodyContains(t, "text/html", "<p>confidential</p>")
+	inner.AssertFile(t, "answers.txt", []byte("1. confidential\n"))
+}
@@ -0,0 +1,135 @@
+package broker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	mechanismPlain       = "PLAIN"
+	mechanismScramSHA512 = "SCRAM-SHA-512"
+)
+
+// brokers returns the bootstrap brokers, falling back to the legacy address and port pair.
+func brokers(cfg config.Kafka) ([]string, error) {
+	var list []string
+	for _, b := range cfg.Brokers {
+		if b = strings.TrimSpace(b); b != "" {
+			list = append(list, b)
+		}
+	}
+	if len(list) > 0 {
+		return list, nil
+	}
+
+	if cfg.Address == "" {
+		return nil, errors.New("no kafka brokers configured")
+	}
+
+	return []string{net.JoinHostPort(cfg.Address, cfg.Port)}, nil
+}
+
+// newDialer builds a dialer with the TLS and SASL settings from the config.
+func newDialer(cfg config.Kafka) (*kafka.Dialer, error) {
+	const op = "broker.newDialer"
+
+	dialer := &kafka.Dialer{
+		Timeout:   10 * time.Second,
+		DualStack: true,
+	}
+
+	if cfg.TLS.Enabled {
+		tlsCfg, err := newTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		dialer.TLS = tlsCfg
+	}
+
+	if cfg.SASL.Mechanism != "" {
+		mechanism, err := newMechanism(cfg.SASL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		dialer.SASLMechanism = mechanism
+	}
+
+	return dialer, nil
+}
+
+// newTLSConfig loads the custom CA and the client certificate, if any.
+func newTLSConfig(cfg config.KafkaTLS) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		ca, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+// newMechanism creates the SASL mechanism named in the config.
+func newMechanism(cfg config.KafkaSASL) (sasl.Mechanism, error) {
+	password, err := readSecret(cfg.Password, cfg.PasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("read sasl password: %w", err)
+	}
+
+	switch strings.ToUpper(cfg.Mechanism) {
+	case mechanismPlain:
+		return plain.Mechanism{Username: cfg.Username, Password: password}, nil
+	case mechanismScramSHA512:
+		mechanism, err := scram.Mechanism(scram.SHA512, cfg.Username, password)
+		if err != nil {
+			return nil, fmt.Errorf("create scram mechanism: %w", err)
+		}
+		return mechanism, nil
+	default:
+		return nil, fmt.Errorf("unsupported sasl mechanism %q", cfg.Mechanism)
+	}
+}
+
+// readSecret returns the value itself or, when it is empty, the trimmed content of the file.
+func readSecret(value, file string) (string, error) {
+	if value != "" || file == "" {
+		return value, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
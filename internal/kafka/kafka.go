@@ -7,62 +7,192 @@ import (
 	"github.com/fentezi/export-word/internal/config"
 	"github.com/segmentio/kafka-go"
 	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
 )
 
+const defaultGroupID = "export-word"
+
+// Message is a single record read from one of the consumed topics.
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+}
+
 type Consumer struct {
 	log    *slog.Logger
 	reader *kafka.Reader
 }
 
 func New(log *slog.Logger, broker config.Kafka) (Consumer, error) {
-	url := fmt.Sprintf("%s:%s", broker.Address, broker.Port)
-	r := kafka.NewReader(
-		kafka.ReaderConfig{
-			Brokers: []string{url},
-			Topic:   broker.Topic,
-		},
+	const op = "broker.New"
+
+	urls, err := brokers(broker)
+	if err != nil {
+		return Consumer{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	dialer, err := newDialer(broker)
+	if err != nil {
+		return Consumer{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	topics, err := resolveTopics(dialer, urls, broker.TopicRules())
+	if err != nil {
+		return Consumer{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info(
+		"kafka reader init", slog.Any("brokers", urls), slog.Any("topics", topics),
+		slog.Bool("tls", dialer.TLS != nil), slog.String("sasl", broker.SASL.Mechanism),
 	)
+	readerCfg := kafka.ReaderConfig{
+		Brokers: urls,
+		GroupID: broker.GroupID,
+		Dialer:  dialer,
+	}
+	// kafka-go only reads several topics and commits offsets as part of a consumer group, and
+	// batched ingestion relies on committing every batch.
+	if readerCfg.GroupID == "" && (len(topics) > 1 || broker.Batch.Size > 1) {
+		readerCfg.GroupID = defaultGroupID
+	}
+	if len(topics) == 1 {
+		readerCfg.Topic = topics[0]
+	} else {
+		readerCfg.GroupTopics = topics
+	}
 
-	return Consumer{log: log, reader: r}, nil
+	return Consumer{log: log, reader: kafka.NewReader(readerCfg)}, nil
 }
 
-func (c *Consumer) Consume(ctx context.Context) (<-chan []byte, error) {
-	ch := make(chan []byte)
-
-	go func() {
-		defer close(ch)
-		c.log.Info("start consume messages")
-		for {
-			select {
-			case <-ctx.Done():
-				c.log.Info("stop consume messages")
-				return
-			default:
-				msg, err := c.reader.ReadMessage(ctx)
-				if err == nil {
-					c.log.Debug(
-						"get message", slog.String("key", string(msg.Key)),
-						slog.String("value", string(msg.Value)),
-					)
-					ch <- msg.Value
-				} else {
-					var kafkaErr kafka.Error
-					if errors.As(err, &kafkaErr) {
-						c.log.Error(
-							"consumer error", slog.String("error", err.Error()),
-							slog.Any("message", msg),
-						)
-					}
+// resolveTopics expands the configured names and patterns into the list of topics to read.
+// Patterns are matched against the topics that exist on the cluster at startup only: a
+// matching topic created later is not consumed until the service is restarted.
+func resolveTopics(dialer *kafka.Dialer, urls []string, rules []config.Topic) ([]string, error) {
+	var (
+		topics   []string
+		patterns []*regexp.Regexp
+		seen     = make(map[string]bool)
+	)
+	for _, r := range rules {
+		if r.Name != "" && !seen[r.Name] {
+			seen[r.Name] = true
+			topics = append(topics, r.Name)
+		}
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("compile topic pattern %q: %w", r.Pattern, err)
+			}
+			patterns = append(patterns, re)
+		}
+	}
+
+	if len(patterns) > 0 {
+		existing, err := listTopics(dialer, urls)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range existing {
+			for _, re := range patterns {
+				if !seen[name] && re.MatchString(name) {
+					seen[name] = true
+					topics = append(topics, name)
 				}
+			}
+		}
+	}
+
+	if len(topics) == 0 {
+		return nil, errors.New("no kafka topics to consume")
+	}
 
+	return topics, nil
+}
+
+// listTopics returns the topic names known to the first reachable broker.
+func listTopics(dialer *kafka.Dialer, urls []string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialer.Timeout)
+	defer cancel()
+
+	var lastErr error
+	for _, url := range urls {
+		conn, err := dialer.DialContext(ctx, "tcp", url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		partitions, err := conn.ReadPartitions()
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		seen := make(map[string]bool)
+		var names []string
+		for _, p := range partitions {
+			if !seen[p.Topic] && !strings.HasPrefix(p.Topic, "__") {
+				seen[p.Topic] = true
+				names = append(names, p.Topic)
 			}
 		}
+		sort.Strings(names)
+		return names, nil
+	}
+
+	return nil, fmt.Errorf("list topics: %w", lastErr)
+}
 
-	}()
-	return ch, nil
+// Fetch returns the next message without committing it. Use Commit once it is stored.
+func (c *Consumer) Fetch(ctx context.Context) (Message, error) {
+	const op = "broker.Fetch"
+	msg, err := c.reader.FetchMessage(ctx)
+	if err != nil {
+		return Message{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	c.log.Debug(
+		"fetch message", slog.String("topic", msg.Topic), slog.Int64("offset", msg.Offset),
+		slog.String("value", string(msg.Value)),
+	)
+	return toMessage(msg), nil
+}
+
+// Commit marks the messages as consumed. It is a no-op when the reader is not part of a
+// consumer group, since kafka has nowhere to store the offsets then.
+func (c *Consumer) Commit(ctx context.Context, msgs ...Message) error {
+	const op = "broker.Commit"
+	if len(msgs) == 0 || c.reader.Config().GroupID == "" {
+		return nil
+	}
+
+	kmsgs := make([]kafka.Message, 0, len(msgs))
+	for _, m := range msgs {
+		kmsgs = append(kmsgs, kafka.Message{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset})
+	}
+	if err := c.reader.CommitMessages(ctx, kmsgs...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
 }
 
 func (c *Consumer) Close() error {
 	c.log.Info("closing kafka client")
 	return c.reader.Close()
 }
+
+func toMessage(msg kafka.Message) Message {
+	return Message{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+	}
+}
@@ -0,0 +1,201 @@
+package mapping
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/google/uuid"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultEventID     = "event_id"
+	defaultWord        = "word"
+	defaultTranslation = "translation"
+	defaultSource      = "source"
+	defaultLangFrom    = "lang_from"
+	defaultLangTo      = "lang_to"
+	defaultExample     = "example"
+	defaultTags        = "tags"
+)
+
+var (
+	ErrNoRule    = errors.New("no mapping rule for topic")
+	ErrEmptyWord = errors.New("word is empty")
+)
+
+// namespace is used to derive stable event IDs for messages that do not carry one.
+var namespace = uuid.MustParse("0b8a4bfc-52d5-4b46-9f4e-3f0c3c1a7a7e")
+
+type rule struct {
+	name    string
+	pattern *regexp.Regexp
+	source  string
+	mapping config.Mapping
+}
+
+// Mapper converts raw messages into entity.KafkaMessage using the rule of the topic they
+// were read from.
+type Mapper struct {
+	rules []rule
+}
+
+// New builds a Mapper from the configured topics. Exact names take precedence over patterns.
+func New(topics []config.Topic) (*Mapper, error) {
+	const op = "mapping.New"
+
+	m := &Mapper{}
+	for _, t := range topics {
+		r := rule{name: t.Name, source: t.Source, mapping: t.Mapping}
+		if t.Pattern != "" {
+			re, err := regexp.Compile(t.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: compile pattern %q: %w", op, t.Pattern, err)
+			}
+			r.pattern = re
+		}
+		if r.name == "" && r.pattern == nil {
+			return nil, fmt.Errorf("%s: topic needs a name or a pattern", op)
+		}
+		m.rules = append(m.rules, r)
+	}
+
+	return m, nil
+}
+
+// Decode maps the JSON value of a message read from topic.
+func (m *Mapper) Decode(topic string, value []byte) (entity.KafkaMessage, error) {
+	const op = "mapping.Decode"
+
+	r, ok := m.match(topic)
+	if !ok {
+		return entity.KafkaMessage{}, fmt.Errorf("%s: %w: %s", op, ErrNoRule, topic)
+	}
+
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return entity.KafkaMessage{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	msg := entity.KafkaMessage{
+		Word:        lookup(doc, pathOr(r.mapping.Word, defaultWord)),
+		Translation: lookup(doc, pathOr(r.mapping.Translation, defaultTranslation)),
+		Source:      lookup(doc, pathOr(r.mapping.Source, defaultSource)),
+		LangFrom:    lookup(doc, pathOr(r.mapping.LangFrom, defaultLangFrom)),
+		LangTo:      lookup(doc, pathOr(r.mapping.LangTo, defaultLangTo)),
+		Example:     lookup(doc, pathOr(r.mapping.Example, defaultExample)),
+		Tags:        lookupList(doc, pathOr(r.mapping.Tags, defaultTags)),
+	}
+	if msg.Word == "" {
+		return entity.KafkaMessage{}, fmt.Errorf("%s: %w", op, ErrEmptyWord)
+	}
+	if msg.Source == "" {
+		msg.Source = r.source
+	}
+
+	if id := lookup(doc, pathOr(r.mapping.EventID, defaultEventID)); id != "" {
+		msg.EventID = eventID(topic, id)
+	} else {
+		msg.EventID = uuid.NewSHA1(
+			namespace, []byte(msg.Source+"\x00"+msg.Word+"\x00"+msg.Translation),
+		)
+	}
+
+	return msg, nil
+}
+
+// eventID returns the ID of the message as a UUID. IDs of another form, such as the numeric
+// update IDs of Telegram, are turned into a UUID derived from the topic and the ID, so that
+// the same ID read from different topics stays distinct.
+func eventID(topic, id string) uuid.UUID {
+	if parsed, err := uuid.Parse(id); err == nil {
+		return parsed
+	}
+	return uuid.NewSHA1(namespace, []byte(topic+":"+id))
+}
+
+func (m *Mapper) match(topic string) (rule, bool) {
+	for _, r := range m.rules {
+		if r.name == topic {
+			return r, true
+		}
+	}
+	for _, r := range m.rules {
+		if r.pattern != nil && r.pattern.MatchString(topic) {
+			return r, true
+		}
+	}
+
+	return rule{}, false
+}
+
+func pathOr(path, def string) string {
+	if path == "" {
+		return def
+	}
+	return path
+}
+
+// lookup walks a dot-separated path through objects and arrays and returns the value as a string.
+func lookup(doc any, path string) string {
+	return scalar(walk(doc, path))
+}
+
+// lookupList returns the strings of an array value, or the comma-separated parts of a string.
+func lookupList(doc any, path string) []string {
+	var list []string
+	switch v := walk(doc, path).(type) {
+	case []any:
+		for _, item := range v {
+			if s := scalar(item); s != "" {
+				list = append(list, s)
+			}
+		}
+	case string:
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				list = append(list, part)
+			}
+		}
+	}
+	return list
+}
+
+func walk(doc any, path string) any {
+	cur := doc
+	for _, key := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case map[string]any:
+			cur = v[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			cur = v[i]
+		default:
+			return nil
+		}
+	}
+	return cur
+}
+
+func scalar(value any) string {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,138 @@
+// Package pgp turns rendered messages into OpenPGP encrypted PGP/MIME messages (RFC 3156).
+package pgp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"os"
+	"strings"
+)
+
+var (
+	ErrNoKey     = errors.New("no OpenPGP key")
+	ErrMalformed = errors.New("malformed message")
+)
+
+// LoadKey reads the public keys of a recipient from an armored or binary key file.
+func LoadKey(path string) (openpgp.EntityList, error) {
+	const op = "pgp.LoadKey"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	var keys openpgp.EntityList
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", op, path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: %s: %w", op, path, ErrNoKey)
+	}
+	return keys, nil
+}
+
+// Encrypt returns the message, rendered with CRLF line endings, as a PGP/MIME message
+// encrypted to the keys. The content header fields and the body become the encrypted part;
+// the other header fields, the subject included, stay readable.
+func Encrypt(msg []byte, to openpgp.EntityList) ([]byte, error) {
+	const op = "pgp.Encrypt"
+
+	if len(to) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoKey)
+	}
+	header, body, ok := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, fmt.Errorf("%s: %w: no header end", op, ErrMalformed)
+	}
+
+	var outer, inner []string
+	for _, field := range splitFields(string(header)) {
+		if strings.HasPrefix(strings.ToLower(field), "content-") {
+			inner = append(inner, field)
+		} else {
+			outer = append(outer, field)
+		}
+	}
+
+	var armored bytes.Buffer
+	aw, err := armor.Encode(&armored, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	pw, err := openpgp.Encrypt(aw, to, nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	for _, field := range inner {
+		if _, err := pw.Write([]byte(field + "\r\n")); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if _, err := pw.Write(append([]byte("\r\n"), body...)); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := pw.Close(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := aw.Close(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	boundary, err := newBoundary()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var out bytes.Buffer
+	for _, field := range outer {
+		out.WriteString(field + "\r\n")
+	}
+	fmt.Fprintf(
+		&out,
+		"Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\";\r\n"+
+			" boundary=%q\r\n\r\n", boundary,
+	)
+	out.WriteString("This is an OpenPGP/MIME encrypted message (RFC 3156).\r\n")
+	fmt.Fprintf(&out, "--%s\r\n", boundary)
+	out.WriteString("Content-Type: application/pgp-encrypted\r\n")
+	out.WriteString("Content-Description: PGP/MIME version identification\r\n\r\n")
+	out.WriteString("Version: 1\r\n\r\n")
+	fmt.Fprintf(&out, "--%s\r\n", boundary)
+	out.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n")
+	out.WriteString("Content-Description: OpenPGP encrypted message\r\n")
+	out.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+	out.WriteString(strings.ReplaceAll(armored.String(), "\n", "\r\n"))
+	fmt.Fprintf(&out, "\r\n--%s--\r\n", boundary)
+	return out.Bytes(), nil
+}
+
+// splitFields splits a header block into fields, keeping folded lines with their field.
+func splitFields(header string) []string {
+	var fields []string
+	for _, line := range strings.Split(header, "\r\n") {
+		if len(fields) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+func newBoundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "pgp-" + hex.EncodeToString(b), nil
+}
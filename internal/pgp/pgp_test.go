@@ -0,0 +1,225 @@
+package pgp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// message is a rendered digest with a folded content field and a non-ASCII body.
+const message = "From: Digest <digest@example.com>\r\n" +
+	"To: reader@example.com\r\n" +
+	"Subject: Words of the day\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative;\r\n" +
+	" boundary=\"b1\"\r\n" +
+	"Content-Transfer-Encoding: 8bit\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"\r\n" +
+	"apple — яблоко\r\n" +
+	"--b1--\r\n"
+
+// newEntity returns a key pair of the algorithm for the reader.
+func newEntity(t *testing.T, algo packet.PublicKeyAlgorithm) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity(
+		"Reader", "", "reader@example.com", &packet.Config{Algorithm: algo, RSABits: 2048},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		algo packet.PublicKeyAlgorithm
+	}{
+		{"rsa", packet.PubKeyAlgoRSA},
+		{"curve25519", packet.PubKeyAlgoEdDSA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newEntity(t, tt.algo)
+			encrypted, err := Encrypt([]byte(message), openpgp.EntityList{reader})
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+
+			msg, err := mail.ReadMessage(bytes.NewReader(encrypted))
+			if err != nil {
+				t.Fatalf("read message: %v", err)
+			}
+			// The routing fields stay readable, the content fields are replaced.
+			for name, want := range map[string]string{
+				"From":                      "Digest <digest@example.com>",
+				"Subject":                   "Words of the day",
+				"MIME-Version":              "1.0",
+				"Content-Transfer-Encoding": "",
+			} {
+				if got := msg.Header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			armored := encryptedPart(t, msg)
+
+			block, err := armor.Decode(bytes.NewReader(armored))
+			if err != nil {
+				t.Fatalf("armor: %v", err)
+			}
+			md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{reader}, nil, nil)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			plain, err := io.ReadAll(md.UnverifiedBody)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !md.IsEncrypted || md.IsSigned {
+				t.Errorf("encrypted = %v, signed = %v", md.IsEncrypted, md.IsSigned)
+			}
+
+			// The decrypted part is the content fields and the body of the original message.
+			_, body, _ := strings.Cut(message, "\r\n\r\n")
+			want := "Content-Type: multipart/alternative;\r\n boundary=\"b1\"\r\n" +
+				"Content-Transfer-Encoding: 8bit\r\n\r\n" + body
+			if string(plain) != want {
+				t.Errorf("decrypted part = %q, want %q", plain, want)
+			}
+		})
+	}
+}
+
+func TestEncryptWrongKeyCannotDecrypt(t *testing.T) {
+	reader, other := newEntity(t, packet.PubKeyAlgoEdDSA), newEntity(t, packet.PubKeyAlgoEdDSA)
+	encrypted, err := Encrypt([]byte(message), openpgp.EntityList{reader})
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := armor.Decode(bytes.NewReader(encryptedPart(t, msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = openpgp.ReadMessage(block.Body, openpgp.EntityList{other}, nil, nil)
+	if err == nil {
+		t.Error("decrypted with another key")
+	}
+	if bytes.Contains(encrypted, []byte("яблоко")) {
+		t.Error("the body is readable in the encrypted message")
+	}
+}
+
+func TestEncryptErrors(t *testing.T) {
+	reader := newEntity(t, packet.PubKeyAlgoEdDSA)
+	if _, err := Encrypt([]byte(message), nil); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Encrypt without keys = %v, want %v", err, ErrNoKey)
+	}
+	_, err := Encrypt([]byte("Subject: no body\r\n"), openpgp.EntityList{reader})
+	if !errors.Is(err, ErrMalformed) {
+		t.Errorf("Encrypt without header end = %v, want %v", err, ErrMalformed)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	reader := newEntity(t, packet.PubKeyAlgoEdDSA)
+	var binary, armored bytes.Buffer
+	if err := reader.Serialize(&binary); err != nil {
+		t.Fatal(err)
+	}
+	aw, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Serialize(aw); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"armored", armored.Bytes(), false},
+		{"binary", binary.Bytes(), false},
+		{"garbage", []byte("not a key"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "key")
+			if err := os.WriteFile(path, tt.data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			keys, err := LoadKey(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if want := reader.PrimaryKey.KeyId; err == nil && keys[0].PrimaryKey.KeyId != want {
+				t.Errorf("loaded key %X, want %X", keys[0].PrimaryKey.KeyId, want)
+			}
+		})
+	}
+}
+
+// encryptedPart checks the PGP/MIME structure of RFC 3156, section 4, and returns the armored
+// data of its second part.
+func encryptedPart(t *testing.T, msg *mail.Message) []byte {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Content-Type: %v", err)
+	}
+	if mediaType != "multipart/encrypted" || params["protocol"] != "application/pgp-encrypted" {
+		t.Fatalf("Content-Type = %s %v", mediaType, params)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var parts [][]byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("part: %v", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("part: %v", err)
+		}
+		wantType := "application/pgp-encrypted"
+		if len(parts) == 1 {
+			wantType = "application/octet-stream"
+		}
+		if got, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); got != wantType {
+			t.Errorf("part %d is %s, want %s", len(parts)+1, got, wantType)
+		}
+		parts = append(parts, data)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("%d parts, want 2", len(parts))
+	}
+	if version := strings.TrimSpace(string(parts[0])); version != "Version: 1" {
+		t.Errorf("version part = %q", version)
+	}
+	return parts[1]
+}
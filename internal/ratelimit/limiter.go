@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/sink"
+	"time"
+)
+
+const dayLayout = "2006-01-02"
+
+// metrics are published at /debug/vars under "mail_limit".
+var metrics = expvar.NewMap("mail_limit")
+
+// Store keeps the daily recipient count.
+type Store interface {
+	ReserveMailQuota(ctx context.Context, day string, n, limit int) (int, bool, error)
+	ReleaseMailQuota(ctx context.Context, day string, n int) error
+}
+
+// Limiter admits mail within the configured rate and daily quota.
+type Limiter struct {
+	bucket *Bucket
+	store  Store
+	quota  int
+	loc    *time.Location
+}
+
+func New(cfg config.MailLimit, store Store) (*Limiter, error) {
+	const op = "ratelimit.New"
+
+	if cfg.Rate < 0 || cfg.DailyQuota < 0 {
+		return nil, fmt.Errorf("%s: negative mail limit", op)
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	l := &Limiter{store: store, quota: cfg.DailyQuota, loc: loc}
+	if cfg.Rate > 0 {
+		l.bucket = NewBucket(cfg.Rate/60, max(cfg.Burst, 1))
+	}
+	return l, nil
+}
+
+// Reserve admits mail to n recipients, counting them against the quota of the day. When the
+// rate is exceeded it returns a *sink.DeferredError until the bucket has the tokens, and when
+// the day is full until the next midnight; a mail that is not admitted takes nothing.
+func (l *Limiter) Reserve(ctx context.Context, n int) error {
+	const op = "ratelimit.Limiter.Reserve"
+
+	now := time.Now().In(l.loc)
+	if n > l.quota && l.quota > 0 {
+		return fmt.Errorf("%s: %d recipients exceed the daily quota of %d", op, n, l.quota)
+	}
+
+	if l.bucket != nil {
+		if wait := l.bucket.Take(now, n); wait > 0 {
+			metrics.Add("deferred_rate", 1)
+			return &sink.DeferredError{Until: now.Add(wait), Reason: "mail rate limit reached"}
+		}
+	}
+
+	if l.quota > 0 {
+		count, ok, err := l.store.ReserveMailQuota(ctx, now.Format(dayLayout), n, l.quota)
+		if (err != nil || !ok) && l.bucket != nil {
+			l.bucket.Put(n)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		used := new(expvar.Int)
+		used.Set(int64(count))
+		metrics.Set("quota_used", used)
+		if !ok {
+			metrics.Add("deferred_quota", 1)
+			y, m, d := now.Date()
+			return &sink.DeferredError{
+				Until:  time.Date(y, m, d+1, 0, 0, 0, 0, l.loc),
+				Reason: fmt.Sprintf("daily mail quota of %d reached", l.quota),
+			}
+		}
+	}
+
+	metrics.Add("admitted", int64(n))
+	return nil
+}
+
+// Release gives back the reservation of mail to n recipients that was not sent, so that only
+// sent mail counts against the rate and the quota of the day.
+func (l *Limiter) Release(ctx context.Context, n int) error {
+	const op = "ratelimit.Limiter.Release"
+
+	if l.bucket != nil {
+		l.bucket.Put(n)
+	}
+	if l.quota > 0 {
+		day := time.Now().In(l.loc).Format(dayLayout)
+		if err := l.store.ReleaseMailQuota(ctx, day, n); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	metrics.Add("released", int64(n))
+	return nil
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/sink"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memQuota keeps the daily counts in memory like the mailQuota collection.
+type memQuota struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (m *memQuota) ReserveMailQuota(
+	ctx context.Context, day string, n, limit int,
+) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts[day]+n > limit {
+		return m.counts[day], false, nil
+	}
+	m.counts[day] += n
+	return m.counts[day], true, nil
+}
+
+func (m *memQuota) ReleaseMailQuota(ctx context.Context, day string, n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts[day] >= n {
+		m.counts[day] -= n
+	}
+	return nil
+}
+
+// deferral returns the reason the mail was deferred for, empty when it was admitted.
+func deferral(t *testing.T, err error) string {
+	t.Helper()
+	var deferred *sink.DeferredError
+	if err != nil && !errors.As(err, &deferred) {
+		t.Fatalf("Reserve: %v", err)
+	}
+	if deferred == nil {
+		return ""
+	}
+	return deferred.Reason
+}
+
+func TestLimiterRelease(t *testing.T) {
+	store := &memQuota{counts: make(map[string]int)}
+	// A bucket of two that refills once a day and a quota of one.
+	l, err := New(config.MailLimit{Rate: 1.0 / 24 / 60, Burst: 2, DailyQuota: 1}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if reason := deferral(t, l.Reserve(ctx, 1)); reason != "" {
+		t.Fatalf("first mail deferred: %s", reason)
+	}
+	// The day is full: the token taken for the mail is given back.
+	if reason := deferral(t, l.Reserve(ctx, 1)); !strings.Contains(reason, "quota") {
+		t.Fatalf("second mail deferred for %q, want the quota", reason)
+	}
+
+	// The first mail failed to send.
+	if err := l.Release(ctx, 1); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if reason := deferral(t, l.Reserve(ctx, 1)); reason != "" {
+		t.Fatalf("mail after the release deferred: %s", reason)
+	}
+	if reason := deferral(t, l.Reserve(ctx, 1)); !strings.Contains(reason, "quota") {
+		t.Errorf("mail deferred for %q, want the quota rather than the rate", reason)
+	}
+	for day, count := range store.counts {
+		if count != 1 {
+			t.Errorf("%s count = %d, want the one sent mail", day, count)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+)
+
+// DigestWordsChunk is the number of event IDs stored per digestWords document, which also
+// bounds the size of the $in filter used to read them back.
+const DigestWordsChunk = 10000
+
+// digestWords is a chunk of the event IDs of a digest, in the order they were exported.
+type digestWords struct {
+	DigestID string      `bson:"digestId"`
+	Seq      int         `bson:"seq"`
+	EventIDs []uuid.UUID `bson:"eventIds"`
+}
+
+// CreateDigest stores the history record of a digest.
+func (r *Repository) CreateDigest(ctx context.Context, digest entity.Digest) error {
+	const op = "repository.CreateDigest"
+	r.logger.Debug("start", slog.String("op", op), slog.String("digest_id", digest.ID))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("digests")
+
+	if _, err := collection.InsertOne(ctx, digest); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AddDigestWords stores the seq-th chunk of the event IDs of a digest.
+func (r *Repository) AddDigestWords(
+	ctx context.Context,
+	digestID string,
+	seq int,
+	eventIDs []uuid.UUID,
+) error {
+	const op = "repository.AddDigestWords"
+	r.logger.Debug(
+		"start", slog.String("op", op), slog.String("digest_id", digestID), slog.Int("seq", seq),
+	)
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("digestWords")
+
+	doc := digestWords{DigestID: digestID, Seq: seq, EventIDs: eventIDs}
+	if _, err := collection.InsertOne(ctx, doc); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ListDigests returns the latest digests, newest first.
+func (r *Repository) ListDigests(ctx context.Context, limit int64) ([]entity.Digest, error) {
+	const op = "repository.ListDigests"
+	r.logger.Debug("start", slog.String("op", op), slog.Int64("limit", limit))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("digests")
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit)
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer cursor.Close(ctx)
+
+	digests := []entity.Digest{}
+	if err := cursor.All(ctx, &digests); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return digests, nil
+}
+
+func (r *Repository) GetDigest(ctx context.Context, digestID string) (entity.Digest, error) {
+	const op = "repository.GetDigest"
+	r.logger.Debug("start", slog.String("op", op), slog.String("digest_id", digestID))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("digests")
+
+	var digest entity.Digest
+	err := collection.FindOne(ctx, bson.M{"_id": digestID}).Decode(&digest)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.Digest{}, fmt.Errorf("%s: %w", op, ErrDocumentNotFound)
+		}
+		return entity.Digest{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return digest, nil
+}
+
+// StreamDigestWords calls fn for the words recorded in the digest's history, in the order
+// they were exported, fetching one chunk of event IDs at a time. Unlike StreamDigest, it
+// still finds words that have since been claimed by a newer digest. Deleted words are
+// skipped. It stops at the first error returned by fn.
+func (r *Repository) StreamDigestWords(
+	ctx context.Context,
+	digestID string,
+	fn func(entity.MongoMessage) error,
+) error {
+	const op = "repository.StreamDigestWords"
+	r.logger.Debug("start", slog.String("op", op), slog.String("digest_id", digestID))
+	defer r.logger.Debug("end", slog.String("op", op))
+	db := r.client.Database(r.cfg.Database)
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	chunks, err := db.Collection("digestWords").Find(ctx, bson.M{"digestId": digestID}, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer chunks.Close(ctx)
+
+	for chunks.Next(ctx) {
+		var chunk digestWords
+		if err := chunks.Decode(&chunk); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		cursor, err := db.Collection("words").Find(
+			ctx, bson.M{"eventId": bson.M{"$in": chunk.EventIDs}},
+			options.Find().SetBatchSize(streamBatchSize),
+		)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		byID := make(map[uuid.UUID]entity.MongoMessage, len(chunk.EventIDs))
+		for cursor.Next(ctx) {
+			var msg entity.MongoMessage
+			if err := cursor.Decode(&msg); err != nil {
+				cursor.Close(ctx)
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			byID[msg.EventID] = msg
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		for _, id := range chunk.EventIDs {
+			msg, ok := byID[id]
+			if !ok {
+				continue
+			}
+			if err := fn(msg); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+	}
+
+	if err := chunks.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ListOutboxEntries returns the deliveries of the digest without their rendered content,
+// oldest first.
+func (r *Repository) ListOutboxEntries(
+	ctx context.Context,
+	digestID string,
+) ([]entity.OutboxEntry, error) {
+	const op = "repository.ListOutboxEntries"
+	r.logger.Debug("start", slog.String("op", op), slog.String("digest_id", digestID))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("outbox")
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetProjection(bson.M{"html": 0, "attachments": 0})
+	cursor, err := collection.Find(ctx, bson.M{"digestId": digestID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer cursor.Close(ctx)
+
+	entries := []entity.OutboxEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entries, nil
+}
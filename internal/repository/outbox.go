@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"time"
+)
+
+// CreateOutboxEntries stores the deliveries of a digest in the outbox collection.
+func (r *Repository) CreateOutboxEntries(ctx context.Context, entries []entity.OutboxEntry) error {
+	const op = "repository.CreateOutboxEntries"
+	r.logger.Debug("start", slog.String("op", op), slog.Int("count", len(entries)))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("outbox")
+
+	docs := make([]any, 0, len(entries))
+	for _, e := range entries {
+		docs = append(docs, e)
+	}
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ClaimOutboxEntry returns the pending entry with the earliest attempt time not after now and
+// moves its attempt time to now plus lease, so that no other worker picks it up while it is
+// delivered. An entry whose worker died is retried once the lease has passed. It returns
+// ErrDocumentNotFound when no entry is due.
+func (r *Repository) ClaimOutboxEntry(
+	ctx context.Context,
+	now time.Time,
+	lease time.Duration,
+) (entity.OutboxEntry, error) {
+	const op = "repository.ClaimOutboxEntry"
+	r.logger.Debug("start", slog.String("op", op))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("outbox")
+
+	var entry entity.OutboxEntry
+	err := collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"status": entity.OutboxPending, "nextAttemptAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"nextAttemptAt": now.Add(lease)}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&entry)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return entity.OutboxEntry{}, fmt.Errorf("%s: %w", op, ErrDocumentNotFound)
+		}
+		return entity.OutboxEntry{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return entry, nil
+}
+
+// UpdateOutboxEntry stores the outcome of a delivery attempt: the status, attempt count, next
+// attempt time, last error and delivery time of the entry.
+func (r *Repository) UpdateOutboxEntry(ctx context.Context, entry entity.OutboxEntry) error {
+	const op = "repository.UpdateOutboxEntry"
+	r.logger.Debug(
+		"start", slog.String("op", op), slog.String("id", entry.ID),
+		slog.String("status", entry.Status),
+	)
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("outbox")
+
+	_, err := collection.UpdateByID(ctx, entry.ID, bson.M{"$set": bson.M{
+		"status":        entry.Status,
+		"attempts":      entry.Attempts,
+		"nextAttemptAt": entry.NextAttemptAt,
+		"lastError":     entry.LastError,
+		"deliveredAt":   entry.DeliveredAt,
+	}})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// PendingOutboxFiles returns the export files that pending entries still have to deliver.
+func (r *Repository) PendingOutboxFiles(ctx context.Context) ([]string, error) {
+	const op = "repository.PendingOutboxFiles"
+	r.logger.Debug("start", slog.String("op", op))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("outbox")
+
+	values, err := collection.Distinct(ctx, "file", bson.M{"status": entity.OutboxPending})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	files := make([]string, 0, len(values))
+	for _, v := range values {
+		if file, ok := v.(string); ok && file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
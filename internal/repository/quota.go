@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+)
+
+// mailQuota counts the recipients mailed on a day.
+type mailQuota struct {
+	Day   string `bson:"_id"`
+	Count int    `bson:"count"`
+}
+
+// ReserveMailQuota adds n recipients to the count of the day unless that would exceed limit.
+// It returns the count of the day and whether the recipients were added.
+func (r *Repository) ReserveMailQuota(
+	ctx context.Context,
+	day string,
+	n, limit int,
+) (int, bool, error) {
+	const op = "repository.ReserveMailQuota"
+	r.logger.Debug("start", slog.String("op", op), slog.String("day", day), slog.Int("n", n))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("mailQuota")
+
+	// Once the day is full the filter matches nothing and the upsert collides with the
+	// existing document.
+	var quota mailQuota
+	err := collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": day, "count": bson.M{"$lte": limit - n}},
+		bson.M{"$inc": bson.M{"count": n}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&quota)
+	if err == nil {
+		return quota.Count, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = collection.FindOne(ctx, bson.M{"_id": day}).Decode(&quota)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+	return quota.Count, false, nil
+}
+
+// ReleaseMailQuota takes n recipients off the count of the day, for mail reserved but not sent.
+// The count does not go below zero.
+func (r *Repository) ReleaseMailQuota(ctx context.Context, day string, n int) error {
+	const op = "repository.ReleaseMailQuota"
+	r.logger.Debug("start", slog.String("op", op), slog.String("day", day), slog.Int("n", n))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("mailQuota")
+
+	_, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": day, "count": bson.M{"$gte": n}},
+		bson.M{"$inc": bson.M{"count": -n}},
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
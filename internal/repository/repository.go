@@ -11,8 +11,12 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log/slog"
+	"time"
 )
 
+// streamBatchSize is the number of documents fetched per cursor round trip.
+const streamBatchSize = 1000
+
 var (
 	ErrDocumentNotFound = errors.New("document not found")
 )
@@ -82,50 +86,200 @@ func (r *Repository) GetWord(ctx context.Context, word string) (entity.MongoMess
 	return msg, nil
 }
 
-func (r *Repository) GetWords(ctx context.Context) ([]entity.MongoMessage, error) {
-	const op = "repository.GetWords"
-	r.logger.Debug("start", slog.String("op", op))
+// ClaimWords assigns every word due before dueBy to the digest so that it can be streamed and
+// marked as sent without holding the event IDs in memory. Words left over from a failed digest
+// are still due and are claimed again; words of a digest whose own deliveries, resends aside,
+// are still pending, retried or deferred are left to it.
+func (r *Repository) ClaimWords(
+	ctx context.Context,
+	digestID string,
+	dueBy time.Time,
+) (int64, error) {
+	const op = "repository.ClaimWords"
+	r.logger.Debug(
+		"start", slog.String("op", op), slog.String("digest_id", digestID),
+		slog.Time("due_by", dueBy),
+	)
+	defer r.logger.Debug("end", slog.String("op", op))
+	db := r.client.Database(r.cfg.Database)
+
+	pending, err := db.Collection("outbox").Distinct(
+		ctx, "digestId", bson.M{"status": entity.OutboxPending, "resend": bson.M{"$ne": true}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	filter := bson.M{"$and": bson.A{
+		dueFilter(dueBy),
+		bson.M{"digestId": bson.M{"$nin": pending}},
+	}}
+	res, err := db.Collection("words").UpdateMany(
+		ctx, filter, bson.M{"$set": bson.M{"digestId": digestID}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// dueFilter matches the words due before dueBy. Words stored before spaced repetition was
+// introduced have no due date and are due while they are unsent.
+func dueFilter(dueBy time.Time) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"srs.due": bson.M{"$lt": dueBy}},
+		bson.M{"srs.due": bson.M{"$exists": false}, "sent": false},
+	}}
+}
+
+// StreamDigest calls fn for every word of the digest in insertion order, decoding them one at a
+// time from the cursor. It stops at the first error returned by fn.
+func (r *Repository) StreamDigest(
+	ctx context.Context,
+	digestID string,
+	fn func(entity.MongoMessage) error,
+) error {
+	const op = "repository.StreamDigest"
+	r.logger.Debug("start", slog.String("op", op), slog.String("digest_id", digestID))
 	defer r.logger.Debug("end", slog.String("op", op))
 	collection := r.client.Database(r.cfg.Database).Collection("words")
 
-	cursor, err := collection.Find(ctx, bson.M{"sent": false})
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(streamBatchSize)
+	cursor, err := collection.Find(ctx, bson.M{"digestId": digestID}, opts)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 	defer cursor.Close(ctx)
 
-	var messages []entity.MongoMessage
 	for cursor.Next(ctx) {
 		var msg entity.MongoMessage
 		if err := cursor.Decode(&msg); err != nil {
-			return nil, fmt.Errorf("%s: %w", op, err)
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if err := fn(msg); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
 		}
-		messages = append(messages, msg)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	return messages, nil
+	return nil
 }
 
-func (r *Repository) UpdateWord(ctx context.Context, eventID uuid.UUID) error {
-	const op = "repository.UpdateWord"
-	r.logger.Debug("start", slog.String("op", op), slog.Any("event_id", eventID))
+// StreamWords calls fn for every stored word, sent or not, in insertion order. It stops at the
+// first error returned by fn.
+func (r *Repository) StreamWords(ctx context.Context, fn func(entity.MongoMessage) error) error {
+	const op = "repository.StreamWords"
+	r.logger.Debug("start", slog.String("op", op))
 	defer r.logger.Debug("end", slog.String("op", op))
 	collection := r.client.Database(r.cfg.Database).Collection("words")
 
-	_, err := collection.UpdateOne(
-		ctx, bson.M{"eventId": eventID}, bson.M{"$set": bson.M{"sent": true}},
-	)
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(streamBatchSize)
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var msg entity.MongoMessage
+		if err := cursor.Decode(&msg); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if err := fn(msg); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
 
 	return nil
 }
 
+// MarkDigestSent flags every word of the digest as sent with a single UpdateMany.
+func (r *Repository) MarkDigestSent(ctx context.Context, digestID string) (int64, error) {
+	const op = "repository.MarkDigestSent"
+	r.logger.Debug("start", slog.String("op", op), slog.String("digest_id", digestID))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("words")
+
+	res, err := collection.UpdateMany(
+		ctx, bson.M{"digestId": digestID},
+		bson.M{"$set": bson.M{"sent": true, "sentAt": time.Now().UTC()}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// RescheduleDigest streams the words of the digest and stores the spaced-repetition state
+// returned by fn, flushing the updates in unordered bulk writes of streamBatchSize words.
+// The previous state is kept in prevSrs so that a later review can replace the implicit one.
+// Words already scheduled by the digest, by an earlier call or a review, are left as they are,
+// so that calling it again, after a resend, changes nothing.
+func (r *Repository) RescheduleDigest(
+	ctx context.Context,
+	digestID string,
+	fn func(entity.MongoMessage) entity.SRS,
+) (int64, error) {
+	const op = "repository.RescheduleDigest"
+	r.logger.Debug("start", slog.String("op", op), slog.String("digest_id", digestID))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("words")
+
+	var (
+		modified int64
+		models   = make([]mongo.WriteModel, 0, streamBatchSize)
+	)
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		res, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+		modified += res.ModifiedCount
+		models = models[:0]
+		return nil
+	}
+
+	err := r.StreamDigest(ctx, digestID, func(msg entity.MongoMessage) error {
+		if msg.ScheduledBy == digestID {
+			return nil
+		}
+		models = append(
+			models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{
+					"eventId":     msg.EventID,
+					"digestId":    digestID,
+					"scheduledBy": bson.M{"$ne": digestID},
+				}).
+				SetUpdate(bson.M{"$set": bson.M{
+					"srs": fn(msg), "prevSrs": msg.SRS, "scheduledBy": digestID,
+				}}),
+		)
+		if len(models) < streamBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return modified, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return modified, nil
+}
+
 func (r *Repository) GetWordByEventID(
 	ctx context.Context,
 	eventID uuid.UUID,
@@ -148,3 +302,63 @@ func (r *Repository) GetWordByEventID(
 	return msg, nil
 
 }
+
+// UpsertWords inserts the words whose event IDs are not stored yet with a single unordered
+// bulk write and returns how many were inserted.
+func (r *Repository) UpsertWords(ctx context.Context, msgs []entity.MongoMessage) (int64, error) {
+	const op = "repository.UpsertWords"
+	r.logger.Debug("start", slog.String("op", op), slog.Int("count", len(msgs)))
+	defer r.logger.Debug("end", slog.String("op", op))
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	collection := r.client.Database(r.cfg.Database).Collection("words")
+
+	models := make([]mongo.WriteModel, 0, len(msgs))
+	for _, msg := range msgs {
+		models = append(
+			models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"eventId": msg.EventID}).
+				SetUpdate(bson.M{"$setOnInsert": msg}).
+				SetUpsert(true),
+		)
+	}
+
+	res, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return res.UpsertedCount, nil
+}
+
+// ApplyReview stores the state the review results in and appends the review to the history of
+// the word with a single update, so that the two never disagree. The word counts as scheduled
+// by the digest, whose deliveries then leave it as reviewed. The update only applies while the
+// word is still in the digest the review was given for; otherwise it returns
+// ErrDocumentNotFound.
+func (r *Repository) ApplyReview(ctx context.Context, review entity.Review) error {
+	const op = "repository.ApplyReview"
+	r.logger.Debug("start", slog.String("op", op), slog.Any("review", review))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("words")
+
+	res, err := collection.UpdateOne(
+		ctx,
+		bson.M{"eventId": review.EventID, "digestId": review.DigestID},
+		bson.M{
+			"$set": bson.M{
+				"srs": review.After, "prevSrs": review.Before, "scheduledBy": review.DigestID,
+			},
+			"$push": bson.M{"reviews": review},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("%s: %w", op, ErrDocumentNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"log/slog"
+	"time"
+)
+
+// createdAt falls back to the ObjectID timestamp for words stored before createdAt existed.
+var createdAt = bson.M{"$ifNull": bson.A{"$createdAt", bson.M{"$toDate": "$_id"}}}
+
+// CountWordsByDate groups the words added since the given time by their creation date rendered
+// with the $dateToString format in the timezone, e.g. "%Y-%m-%d" for days or "%G-W%V" for ISO
+// weeks. A zero since counts all words. Groups are sorted by key.
+func (r *Repository) CountWordsByDate(
+	ctx context.Context,
+	format string,
+	since time.Time,
+	timezone string,
+) ([]entity.Count, error) {
+	const op = "repository.CountWordsByDate"
+	r.logger.Debug("start", slog.String("op", op), slog.String("format", format))
+	defer r.logger.Debug("end", slog.String("op", op))
+
+	pipeline := bson.A{
+		bson.M{"$addFields": bson.M{"_created": createdAt}},
+		bson.M{"$match": bson.M{"_created": bson.M{"$gte": since}}},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{"$dateToString": bson.M{
+				"format": format, "date": "$_created", "timezone": timezone,
+			}},
+			"count": bson.M{"$sum": 1},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	}
+
+	counts, err := r.aggregateCounts(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return counts, nil
+}
+
+// CountWordsByFields groups all words by the listed fields joined with sep. Missing fields are
+// rendered as "unknown". Groups are sorted by count, largest first.
+func (r *Repository) CountWordsByFields(
+	ctx context.Context,
+	sep string,
+	fields ...string,
+) ([]entity.Count, error) {
+	const op = "repository.CountWordsByFields"
+	r.logger.Debug("start", slog.String("op", op), slog.Any("fields", fields))
+	defer r.logger.Debug("end", slog.String("op", op))
+
+	parts := bson.A{}
+	for i, f := range fields {
+		if i > 0 {
+			parts = append(parts, sep)
+		}
+		parts = append(parts, bson.M{"$ifNull": bson.A{
+			bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$" + f, ""}}, nil, "$" + f}},
+			"unknown",
+		}})
+	}
+
+	pipeline := bson.A{
+		bson.M{"$group": bson.M{"_id": bson.M{"$concat": parts}, "count": bson.M{"$sum": 1}}},
+		bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	}
+
+	counts, err := r.aggregateCounts(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return counts, nil
+}
+
+// CountWords returns the number of stored words, the unsent ones and the ones due before dueBy.
+func (r *Repository) CountWords(
+	ctx context.Context,
+	dueBy time.Time,
+) (total, unsent, due int64, err error) {
+	const op = "repository.CountWords"
+	r.logger.Debug("start", slog.String("op", op))
+	defer r.logger.Debug("end", slog.String("op", op))
+	collection := r.client.Database(r.cfg.Database).Collection("words")
+
+	if total, err = collection.EstimatedDocumentCount(ctx); err != nil {
+		return 0, 0, 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if unsent, err = collection.CountDocuments(ctx, bson.M{"sent": false}); err != nil {
+		return 0, 0, 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if due, err = collection.CountDocuments(ctx, dueFilter(dueBy)); err != nil {
+		return 0, 0, 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return total, unsent, due, nil
+}
+
+func (r *Repository) aggregateCounts(ctx context.Context, pipeline bson.A) ([]entity.Count, error) {
+	collection := r.client.Database(r.cfg.Database).Collection("words")
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var counts []entity.Count
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,122 @@
+package review
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/srs"
+	"github.com/google/uuid"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	paramWord    = "w"
+	paramDigest  = "d"
+	paramGrade   = "g"
+	paramExpires = "exp"
+	paramSig     = "sig"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("link expired")
+)
+
+var grades = []srs.Grade{srs.Again, srs.Hard, srs.Good, srs.Easy}
+
+// Claims is what a review link vouches for.
+type Claims struct {
+	EventID  uuid.UUID
+	DigestID string
+	Grade    srs.Grade
+	Expires  time.Time
+}
+
+// Signer creates and verifies HMAC-SHA256 signed review links.
+type Signer struct {
+	secret  []byte
+	ttl     time.Duration
+	baseURL string
+}
+
+// NewSigner returns a signer for links pointing at baseURL + "/review".
+func NewSigner(secret string, ttl time.Duration, baseURL string) Signer {
+	return Signer{
+		secret:  []byte(secret),
+		ttl:     ttl,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// Links returns one signed link per grade for the word in the digest.
+func (s Signer) Links(eventID uuid.UUID, digestID string, now time.Time) map[srs.Grade]string {
+	expires := now.Add(s.ttl)
+	links := make(map[srs.Grade]string, len(grades))
+	for _, g := range grades {
+		c := Claims{EventID: eventID, DigestID: digestID, Grade: g, Expires: expires}
+		links[g] = s.baseURL + "/review?" + s.query(c).Encode()
+	}
+	return links
+}
+
+// Grades returns the grades in the order they should be shown.
+func Grades() []srs.Grade {
+	return grades
+}
+
+// Verify checks the signature and the expiry of the link query.
+func (s Signer) Verify(q url.Values, now time.Time) (Claims, error) {
+	const op = "review.Verify"
+
+	exp, err := strconv.ParseInt(q.Get(paramExpires), 10, 64)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidSignature)
+	}
+	grade, err := srs.ParseGrade(q.Get(paramGrade))
+	if err != nil {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidSignature)
+	}
+	eventID, err := uuid.Parse(q.Get(paramWord))
+	if err != nil {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidSignature)
+	}
+
+	c := Claims{
+		EventID:  eventID,
+		DigestID: q.Get(paramDigest),
+		Grade:    grade,
+		Expires:  time.Unix(exp, 0),
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get(paramSig))
+	if err != nil || !hmac.Equal(sig, s.sign(c)) {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrInvalidSignature)
+	}
+	if now.After(c.Expires) {
+		return Claims{}, fmt.Errorf("%s: %w", op, ErrExpired)
+	}
+
+	return c, nil
+}
+
+func (s Signer) query(c Claims) url.Values {
+	return url.Values{
+		paramWord:    {c.EventID.String()},
+		paramDigest:  {c.DigestID},
+		paramGrade:   {c.Grade.String()},
+		paramExpires: {strconv.FormatInt(c.Expires.Unix(), 10)},
+		paramSig:     {base64.RawURLEncoding.EncodeToString(s.sign(c))},
+	}
+}
+
+func (s Signer) sign(c Claims) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(
+		mac, "%s|%s|%s|%d", c.EventID, c.DigestID, c.Grade, c.Expires.Unix(),
+	)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/book"
+	"log/slog"
+	"net/http"
+)
+
+// Publisher builds the vocabulary book from every stored word. An empty group selects the
+// configured default.
+type Publisher interface {
+	Book(ctx context.Context, group string) (*book.Book, error)
+}
+
+// BookHandler serves the vocabulary book as a download. The "format" query parameter is
+// "markdown" (default) or "epub" and "group" is "alpha" or "tag".
+func BookHandler(logger *slog.Logger, publisher Publisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = book.FormatMarkdown
+		}
+		var contentType, ext string
+		switch format {
+		case book.FormatMarkdown:
+			contentType, ext = "text/markdown; charset=utf-8", "md"
+		case book.FormatEPUB:
+			contentType, ext = "application/epub+zip", "epub"
+		default:
+			http.Error(w, book.ErrUnknownFormat.Error(), http.StatusBadRequest)
+			return
+		}
+
+		b, err := publisher.Book(r.Context(), r.URL.Query().Get("group"))
+		if err != nil {
+			if errors.Is(err, book.ErrUnknownGroup) {
+				http.Error(w, book.ErrUnknownGroup.Error(), http.StatusBadRequest)
+				return
+			}
+			logger.Error("failed to build book", slog.String("error", err.Error()))
+			http.Error(w, "failed to build book", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set(
+			"Content-Disposition",
+			fmt.Sprintf(`attachment; filename="vocabulary-%s.%s"`, b.GeneratedAt.Format("2006-01-02"), ext),
+		)
+		if format == book.FormatEPUB {
+			err = b.WriteEPUB(w)
+		} else {
+			err = b.WriteMarkdown(w)
+		}
+		if err != nil {
+			logger.Error("failed to write book", slog.String("error", err.Error()))
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/repository"
+	"github.com/fentezi/export-word/internal/sink"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrEmptyDigest is returned by History when none of the words of a digest to resend exists
+// any more.
+var ErrEmptyDigest = errors.New("digest has no words left")
+
+// History lists past digests and resends them.
+type History interface {
+	Digests(ctx context.Context, limit int) ([]entity.Digest, error)
+	Digest(ctx context.Context, digestID string) (entity.Digest, []entity.OutboxEntry, error)
+	Resend(ctx context.Context, digestID string, to []string, sinks []string) (int, error)
+}
+
+type digestResponse struct {
+	entity.Digest
+	Deliveries []entity.OutboxEntry `json:"deliveries"`
+}
+
+// resendRequest is the optional body of a resend. Empty fields keep the original recipients
+// and their sinks.
+type resendRequest struct {
+	To    []string `json:"to"`
+	Sinks []string `json:"sinks"`
+}
+
+// RequireToken rejects requests without the bearer token.
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// DigestsHandler lists the latest digests; the "limit" query parameter caps their number.
+func DigestsHandler(logger *slog.Logger, history History) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := 0
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+				return
+			}
+			limit = n
+		}
+
+		digests, err := history.Digests(r.Context(), limit)
+		if err != nil {
+			logger.Error("failed to list digests", slog.String("error", err.Error()))
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		writeJSON(w, http.StatusOK, digests)
+	})
+}
+
+// DigestHandler shows a digest with the status of its deliveries.
+func DigestHandler(logger *slog.Logger, history History) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, deliveries, err := history.Digest(r.Context(), r.PathValue("id"))
+		if err != nil {
+			writeHistoryError(logger, w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, digestResponse{Digest: d, Deliveries: deliveries})
+	})
+}
+
+// ResendHandler queues a digest for delivery again. The deliveries are sent by the outbox.
+func ResendHandler(logger *slog.Logger, history History) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req resendRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body"})
+				return
+			}
+		}
+
+		queued, err := history.Resend(r.Context(), r.PathValue("id"), req.To, req.Sinks)
+		if err != nil {
+			writeHistoryError(logger, w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]int{"queued": queued})
+	})
+}
+
+func writeHistoryError(logger *slog.Logger, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrDocumentNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "digest not found"})
+	case errors.Is(err, ErrEmptyDigest):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": ErrEmptyDigest.Error()})
+	case errors.Is(err, sink.ErrUnknownSink):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	default:
+		logger.Error("digest history request failed", slog.String("error", err.Error()))
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/fentezi/export-word/internal/repository"
+	"github.com/fentezi/export-word/internal/review"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ErrStaleReview is returned by a Reviewer when the word was mailed in a newer digest than the
+// one the link belongs to.
+var ErrStaleReview = errors.New("review link is stale")
+
+var reviewTemplate = template.Must(template.New("review").Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif;"><p>{{.Text}}</p>
+{{- if .Grade}}
+<form method="post"><button type="submit">Save "{{.Grade}}"</button></form>
+{{- end}}
+</body></html>
+`))
+
+// Reviewer applies a verified review to the word.
+type Reviewer interface {
+	Review(ctx context.Context, claims review.Claims) error
+}
+
+// ReviewPageHandler verifies the signed link and asks to confirm the grade with a form that
+// posts back to the same link. Opening a link records nothing, so that mail scanners and
+// link previews following every grade link do not answer for the reader.
+func ReviewPageHandler(logger *slog.Logger, signer review.Signer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := verifyReview(w, r, logger, signer)
+		if !ok {
+			return
+		}
+		grade := claims.Grade.String()
+		reviewPage(w, http.StatusOK, "Record your answer for this word?", grade)
+	})
+}
+
+// ReviewHandler verifies the signed link posted by the review page and records the review.
+func ReviewHandler(logger *slog.Logger, signer review.Signer, reviewer Reviewer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := verifyReview(w, r, logger, signer)
+		if !ok {
+			return
+		}
+
+		err := reviewer.Review(r.Context(), claims)
+		switch {
+		case err == nil:
+			reviewPage(w, http.StatusOK, "Saved: "+claims.Grade.String()+".", "")
+		case errors.Is(err, ErrStaleReview):
+			reviewPage(
+				w, http.StatusGone, "This word has been sent again since, use the newer link.", "",
+			)
+		case errors.Is(err, repository.ErrDocumentNotFound):
+			reviewPage(w, http.StatusNotFound, "This word no longer exists.", "")
+		default:
+			logger.Error("failed to save review", slog.String("error", err.Error()))
+			reviewPage(
+				w, http.StatusInternalServerError, "Something went wrong, try again later.", "",
+			)
+		}
+	})
+}
+
+// verifyReview checks the signed link in the query and renders the error page when it is not
+// valid.
+func verifyReview(
+	w http.ResponseWriter, r *http.Request, logger *slog.Logger, signer review.Signer,
+) (review.Claims, bool) {
+	claims, err := signer.Verify(r.URL.Query(), time.Now())
+	if err != nil {
+		logger.Info("rejected review link", slog.String("error", err.Error()))
+		if errors.Is(err, review.ErrExpired) {
+			reviewPage(w, http.StatusGone, "This link has expired.", "")
+			return review.Claims{}, false
+		}
+		reviewPage(w, http.StatusForbidden, "This link is not valid.", "")
+		return review.Claims{}, false
+	}
+	return claims, true
+}
+
+// reviewPage renders text and, when grade is set, the form confirming it.
+func reviewPage(w http.ResponseWriter, status int, text, grade string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = reviewTemplate.Execute(w, struct{ Text, Grade string }{text, grade})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"github.com/fentezi/export-word/internal/review"
+	"github.com/fentezi/export-word/internal/srs"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingReviewer struct {
+	reviews []review.Claims
+}
+
+func (r *recordingReviewer) Review(_ context.Context, claims review.Claims) error {
+	r.reviews = append(r.reviews, claims)
+	return nil
+}
+
+func TestReviewHandlers(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	signer := review.NewSigner("secret", time.Hour, "http://localhost")
+	reviewer := &recordingReviewer{}
+	mux := http.NewServeMux()
+	mux.Handle("GET /review", ReviewPageHandler(logger, signer))
+	mux.Handle("POST /review", ReviewHandler(logger, signer, reviewer))
+
+	link, err := url.Parse(signer.Links(uuid.New(), "digest", time.Now())[srs.Hard])
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := strings.Replace(link.RawQuery, "g=hard", "g=easy", 1)
+
+	tests := []struct {
+		name    string
+		method  string
+		query   string
+		status  int
+		body    string
+		reviews int
+	}{
+		{"opening the link only asks", http.MethodGet, link.RawQuery, 200, `method="post"`, 0},
+		{"posting the link records", http.MethodPost, link.RawQuery, 200, "Saved: hard.", 1},
+		{"forged link is shown nothing", http.MethodGet, forged, 403, "not valid", 1},
+		{"forged link records nothing", http.MethodPost, forged, 403, "not valid", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, "/review?"+tt.query, nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("body %q lacks %q", rec.Body.String(), tt.body)
+			}
+			if len(reviewer.reviews) != tt.reviews {
+				t.Errorf("%d reviews recorded, want %d", len(reviewer.reviews), tt.reviews)
+			}
+		})
+	}
+}
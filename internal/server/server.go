@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// Server is the HTTP server listening on the configured host and port.
+type Server struct {
+	logger *slog.Logger
+	mux    *http.ServeMux
+	srv    *http.Server
+}
+
+func New(logger *slog.Logger, cfg config.Server) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		logger: logger,
+		mux:    mux,
+		srv: &http.Server{
+			Addr:              net.JoinHostPort(cfg.Host, cfg.Port),
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Handle registers the handler for the pattern, e.g. "GET /review".
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// ServeHTTP dispatches the request to the registered handlers, as the listening server does.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run serves until the context is cancelled and then shuts the server down gracefully.
+func (s *Server) Run(ctx context.Context) error {
+	const op = "server.Run"
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("http server listening", slog.String("addr", s.srv.Addr))
+		errCh <- s.srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	s.logger.Info("http server shutting down")
+	if err := s.srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fentezi/export-word/internal/stats"
+	"log/slog"
+	"net/http"
+)
+
+// Reporter builds the learning statistics.
+type Reporter interface {
+	Report(ctx context.Context) (stats.Report, error)
+}
+
+// StatsHandler serves the statistics report as JSON.
+func StatsHandler(logger *slog.Logger, reporter Reporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := reporter.Report(r.Context())
+		if err != nil {
+			logger.Error("failed to build statistics", slog.String("error", err.Error()))
+			http.Error(w, "failed to build statistics", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/kafka"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// retryMin and retryMax bound the backoff of the consumers while the broker or the database
+// fails.
+const (
+	retryMin = time.Second
+	retryMax = time.Minute
+)
+
+// consumeBatches reads messages in batches of up to cfg.Kafka.Batch.Size messages or
+// cfg.Kafka.Batch.Timeout, stores each batch with one bulk write and commits its offsets.
+func (s *Service) consumeBatches(ctx context.Context) {
+	s.logger.Info(
+		"start consume batches", slog.Int("size", s.cfg.Kafka.Batch.Size),
+		slog.Duration("timeout", s.cfg.Kafka.Batch.Timeout),
+	)
+
+	wait := retryMin
+	for {
+		batch, err := s.fetchBatch(ctx)
+		if len(batch) > 0 {
+			if !s.storeBatch(ctx, batch) {
+				return
+			}
+		}
+		if err == nil {
+			wait = retryMin
+			continue
+		}
+		if ctx.Err() != nil || errors.Is(err, io.EOF) {
+			s.logger.Info("stop consume batches")
+			return
+		}
+		// The broker is unreachable; back off instead of retrying in a tight loop.
+		s.logger.Error(
+			"failed to fetch message", slog.String("error", err.Error()),
+			slog.Duration("retry_in", wait),
+		)
+		if !sleep(ctx, wait) {
+			s.logger.Info("stop consume batches")
+			return
+		}
+		wait = min(wait*2, retryMax)
+	}
+}
+
+// fetchBatch blocks until the first message arrives and then keeps collecting until the batch
+// is full or the batch timeout since the first message has passed.
+func (s *Service) fetchBatch(ctx context.Context) ([]broker.Message, error) {
+	size := s.cfg.Kafka.Batch.Size
+
+	first, err := s.kafka.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := make([]broker.Message, 0, size)
+	batch = append(batch, first)
+
+	fetchCtx, cancel := context.WithTimeout(ctx, s.cfg.Kafka.Batch.Timeout)
+	defer cancel()
+
+	for len(batch) < size {
+		msg, err := s.kafka.Fetch(fetchCtx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				return batch, nil
+			}
+			return batch, err
+		}
+		batch = append(batch, msg)
+	}
+
+	return batch, nil
+}
+
+// storeBatch writes the batch and commits it, retrying with backoff until it succeeds.
+// It returns false when the context is cancelled before the batch is committed.
+func (s *Service) storeBatch(ctx context.Context, batch []broker.Message) bool {
+	wait := retryMin
+	for {
+		err := s.processBatch(ctx, batch)
+		if err == nil {
+			err = s.kafka.Commit(ctx, batch...)
+		}
+		if err == nil {
+			return true
+		}
+
+		s.logger.Error(
+			"failed to store batch, retrying", slog.String("error", err.Error()),
+			slog.Int("size", len(batch)), slog.Duration("retry_in", wait),
+		)
+		if !sleep(ctx, wait) {
+			return false
+		}
+		wait = min(wait*2, retryMax)
+	}
+}
+
+// sleep waits for d and reports false when the context is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// processBatch decodes the batch, drops duplicate event IDs and upserts the rest.
+// Messages that cannot be decoded are logged and skipped so they do not block the batch.
+func (s *Service) processBatch(ctx context.Context, batch []broker.Message) error {
+	const op = "service.processBatch"
+
+	seen := make(map[uuid.UUID]bool, len(batch))
+	words := make([]entity.MongoMessage, 0, len(batch))
+	for _, msg := range batch {
+		m, err := s.mapper.Decode(msg.Topic, msg.Value)
+		if err != nil {
+			s.logger.Error(
+				"failed to decode message", slog.String("error", err.Error()),
+				slog.String("topic", msg.Topic), slog.String("message", string(msg.Value)),
+			)
+			continue
+		}
+		if seen[m.EventID] {
+			continue
+		}
+		seen[m.EventID] = true
+		words = append(words, toMongoMessage(m))
+	}
+
+	inserted, err := s.repo.UpsertWords(ctx, words)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.logger.Info(
+		"processed batch", slog.Int("messages", len(batch)), slog.Int("unique", len(words)),
+		slog.Int64("inserted", inserted),
+	)
+	return nil
+}
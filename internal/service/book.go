@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/fentezi/export-word/internal/book"
+	"github.com/fentezi/export-word/internal/entity"
+	"time"
+)
+
+// Book collects every stored word, sent or not, into the vocabulary book. An empty group
+// uses the configured one.
+func (s *Service) Book(ctx context.Context, group string) (*book.Book, error) {
+	const op = "service.Book"
+
+	cfg := s.cfg.Export.Book
+	if group == "" {
+		group = cfg.Group
+	}
+	b, err := book.New(cfg.Title, cfg.Author, group, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = s.repo.StreamWords(ctx, func(word entity.MongoMessage) error {
+		b.Add(word)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.logger.Info("book built", "group", group, "count", b.Len())
+	return b, nil
+}
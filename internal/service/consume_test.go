@@ -0,0 +1,446 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/brokertest"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/kafka"
+	"github.com/fentezi/export-word/internal/mapping"
+	"github.com/fentezi/export-word/internal/repository"
+	"github.com/fentezi/export-word/internal/server"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// GetWordByEventID, CreateWord and UpsertWords are the WordStore the consumers write to.
+func (m *memStore) GetWordByEventID(
+	ctx context.Context,
+	eventID uuid.UUID,
+) (entity.MongoMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return entity.MongoMessage{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.index[eventID]
+	if !ok {
+		return entity.MongoMessage{}, fmt.Errorf("memStore: %w", repository.ErrDocumentNotFound)
+	}
+	return m.words[i], nil
+}
+
+func (m *memStore) CreateWord(ctx context.Context, msg entity.MongoMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.insert(msg) {
+		return fmt.Errorf("memStore: duplicate event id %s", msg.EventID)
+	}
+	return nil
+}
+
+func (m *memStore) UpsertWords(ctx context.Context, msgs []entity.MongoMessage) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var inserted int64
+	for _, msg := range msgs {
+		if m.insert(msg) {
+			inserted++
+		}
+	}
+	return inserted, nil
+}
+
+// stored returns "word=translation" for every stored word in order.
+func (m *memStore) stored() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]string, 0, len(m.words))
+	for _, w := range m.words {
+		out = append(out, w.Word+"="+w.Translation)
+	}
+	return out
+}
+
+var (
+	eventA = uuid.MustParse("6f1c3a52-64a8-4c33-9a4b-0b1f8f2b0a01")
+	eventB = uuid.MustParse("6f1c3a52-64a8-4c33-9a4b-0b1f8f2b0a02")
+)
+
+func wordJSON(eventID uuid.UUID, word, translation string) string {
+	if eventID == uuid.Nil {
+		return fmt.Sprintf(`{"word": %q, "translation": %q}`, word, translation)
+	}
+	return fmt.Sprintf(`{"event_id": %q, "word": %q, "translation": %q}`, eventID, word, translation)
+}
+
+type produced struct {
+	topic string
+	value string
+}
+
+type consumeCase struct {
+	name     string
+	existing []entity.MongoMessage
+	messages []produced
+	// want lists the stored words in order as "word=translation".
+	want []string
+}
+
+var consumeCases = []consumeCase{
+	{
+		name: "stores words in fetch order across topics",
+		messages: []produced{
+			{"words", wordJSON(uuid.New(), "apple", "яблоко")},
+			{"books", wordJSON(uuid.New(), "house", "дом")},
+			{"words", wordJSON(uuid.New(), "tree", "дерево")},
+		},
+		want: []string{"apple=яблоко", "house=дом", "tree=дерево"},
+	},
+	{
+		name: "keeps the first of duplicate event ids",
+		messages: []produced{
+			{"words", wordJSON(eventA, "apple", "яблоко")},
+			{"words", wordJSON(eventB, "house", "дом")},
+			{"books", wordJSON(eventA, "apple", "яблоня")},
+		},
+		want: []string{"apple=яблоко", "house=дом"},
+	},
+	{
+		name: "derives the same event id for repeated words without one",
+		messages: []produced{
+			{"words", wordJSON(uuid.Nil, "apple", "яблоко")},
+			{"words", wordJSON(uuid.Nil, "apple", "яблоко")},
+			{"words", wordJSON(uuid.Nil, "apple", "яблоня")},
+		},
+		want: []string{"apple=яблоко", "apple=яблоня"},
+	},
+	{
+		name: "derives event ids that are not uuids per topic",
+		messages: []produced{
+			{"words", `{"event_id": 42, "word": "apple", "translation": "яблоко"}`},
+			{"words", `{"event_id": 42, "word": "apple", "translation": "яблоня"}`},
+			{"books", `{"event_id": 42, "word": "house", "translation": "дом"}`},
+		},
+		want: []string{"apple=яблоко", "house=дом"},
+	},
+	{
+		name:     "skips words already stored",
+		existing: []entity.MongoMessage{{EventID: eventA, Word: "apple", Translation: "яблоко"}},
+		messages: []produced{
+			{"words", wordJSON(eventA, "apple", "яблоко")},
+			{"words", wordJSON(eventB, "house", "дом")},
+		},
+		want: []string{"apple=яблоко", "house=дом"},
+	},
+	{
+		name: "skips messages that cannot be decoded",
+		messages: []produced{
+			{"words", "not json"},
+			{"words", wordJSON(uuid.New(), "apple", "яблоко")},
+			{"words", `{"translation": "без слова"}`},
+			{"unknown", wordJSON(uuid.New(), "tree", "дерево")},
+			{"books", wordJSON(uuid.New(), "house", "дом")},
+		},
+		want: []string{"apple=яблоко", "house=дом"},
+	},
+}
+
+// newConsumeService returns a service reading the "words" and "books" topics from source.
+func newConsumeService(
+	t *testing.T,
+	batch config.Batch,
+	store *memStore,
+	source MessageSource,
+) *Service {
+	t.Helper()
+
+	cfg := config.Kafka{
+		Topics: []config.Topic{{Name: "words"}, {Name: "books", Source: "book"}},
+		Batch:  batch,
+	}
+	mapper, err := mapping.New(cfg.TopicRules())
+	if err != nil {
+		t.Fatalf("mapping.New: %v", err)
+	}
+	return &Service{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		cfg:    config.Config{Kafka: cfg},
+		mapper: mapper,
+		repo:   store,
+		kafka:  source,
+	}
+}
+
+func runConsumeCase(t *testing.T, tc consumeCase, consume func(s *Service, ctx context.Context)) {
+	t.Helper()
+
+	store := newMemStore(tc.existing...)
+	source := brokertest.NewSource(len(tc.messages))
+	for _, m := range tc.messages {
+		source.Produce(m.topic, m.value)
+	}
+	source.End()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consume(newConsumeService(t, config.Batch{Size: 2, Timeout: time.Second}, store, source),
+			context.Background())
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop at the end of the source")
+	}
+
+	if got := strings.Join(store.stored(), ", "); got != strings.Join(tc.want, ", ") {
+		t.Errorf("stored %s, want %s", got, strings.Join(tc.want, ", "))
+	}
+	// Every message is committed once, in order, whether it was stored or skipped.
+	committed := source.Committed()
+	if len(committed) != len(tc.messages) {
+		t.Fatalf("committed %d messages, want %d", len(committed), len(tc.messages))
+	}
+	for i, msg := range committed {
+		if msg.Topic != tc.messages[i].topic || string(msg.Value) != tc.messages[i].value {
+			t.Errorf("commit %d is %s %q, want %s %q", i, msg.Topic, msg.Value,
+				tc.messages[i].topic, tc.messages[i].value)
+		}
+	}
+}
+
+func TestConsumeMessages(t *testing.T) {
+	for _, tc := range consumeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			runConsumeCase(t, tc, func(s *Service, ctx context.Context) {
+				s.consumeMessages(ctx)
+			})
+		})
+	}
+}
+
+func TestConsumeBatches(t *testing.T) {
+	for _, tc := range consumeCases {
+		t.Run(tc.name, func(t *testing.T) {
+			runConsumeCase(t, tc, func(s *Service, ctx context.Context) {
+				s.consumeBatches(ctx)
+			})
+		})
+	}
+}
+
+func TestConsumeStopsOnCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		batch   config.Batch
+		consume func(s *Service, ctx context.Context)
+		// pending messages are produced before the cancellation and never stored.
+		pending int
+	}{
+		{
+			name:    "messages, waiting for a message",
+			consume: func(s *Service, ctx context.Context) { s.consumeMessages(ctx) },
+		},
+		{
+			name:    "batches, waiting for the first message",
+			batch:   config.Batch{Size: 5, Timeout: time.Hour},
+			consume: func(s *Service, ctx context.Context) { s.consumeBatches(ctx) },
+		},
+		{
+			name:    "batches, with a partial batch",
+			batch:   config.Batch{Size: 5, Timeout: time.Hour},
+			consume: func(s *Service, ctx context.Context) { s.consumeBatches(ctx) },
+			pending: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore()
+			source := brokertest.NewSource(tt.pending)
+			for i := range tt.pending {
+				source.Produce("words", wordJSON(uuid.New(), fmt.Sprintf("word%d", i), "слово"))
+			}
+			s := newConsumeService(t, tt.batch, store, source)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				tt.consume(s, ctx)
+			}()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for source.Fetched() < tt.pending && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("consumer did not stop after the context was cancelled")
+			}
+
+			// A batch cut short is neither stored nor committed, so it is fetched again.
+			if got := store.stored(); len(got) != 0 {
+				t.Errorf("stored %v after cancellation", got)
+			}
+			if got := source.Committed(); len(got) != 0 {
+				t.Errorf("committed %d messages after cancellation", len(got))
+			}
+		})
+	}
+}
+
+func TestConsumeCommitFailure(t *testing.T) {
+	errCommit := errors.New("coordinator not available")
+
+	t.Run("messages are stored and left uncommitted", func(t *testing.T) {
+		store := newMemStore()
+		source := brokertest.NewSource(2)
+		source.FailCommits(errCommit)
+		source.Produce(
+			"words", wordJSON(eventA, "apple", "яблоко"), wordJSON(eventB, "house", "дом"),
+		)
+		source.End()
+
+		newConsumeService(t, config.Batch{}, store, source).consumeMessages(context.Background())
+
+		if got := strings.Join(store.stored(), ", "); got != "apple=яблоко, house=дом" {
+			t.Errorf("stored %s", got)
+		}
+		// The consumer moves on; the messages are fetched again after a restart.
+		if got := source.Rejected(); got != 2 {
+			t.Errorf("%d commits rejected, want 2", got)
+		}
+		if got := source.Committed(); len(got) != 0 {
+			t.Errorf("committed %d messages, want none", len(got))
+		}
+	})
+
+	t.Run("a batch is committed once the commit succeeds", func(t *testing.T) {
+		store := newMemStore()
+		source := brokertest.NewSource(2)
+		source.FailCommits(errCommit)
+		source.Produce(
+			"words", wordJSON(eventA, "apple", "яблоко"), wordJSON(eventB, "house", "дом"),
+		)
+		s := newConsumeService(t, config.Batch{Size: 2, Timeout: time.Second}, store, source)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.consumeBatches(context.Background())
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for source.Rejected() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if len(source.Committed()) != 0 {
+			t.Fatal("the batch was committed while commits fail")
+		}
+		source.FailCommits(nil)
+		source.End()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("consumer did not stop at the end of the source")
+		}
+
+		// The retry stores the batch again, which the upsert makes a no-op.
+		if got := strings.Join(store.stored(), ", "); got != "apple=яблоко, house=дом" {
+			t.Errorf("stored %s", got)
+		}
+		if got := source.Committed(); len(got) != 2 {
+			t.Errorf("committed %d messages, want 2", len(got))
+		}
+	})
+}
+
+// failingStore is a memStore whose CreateWord fails while fail is set.
+type failingStore struct {
+	*memStore
+	fail  atomic.Bool
+	calls atomic.Int32
+}
+
+func (f *failingStore) CreateWord(ctx context.Context, msg entity.MongoMessage) error {
+	f.calls.Add(1)
+	if f.fail.Load() {
+		return errors.New("server selection timeout")
+	}
+	return f.memStore.CreateWord(ctx, msg)
+}
+
+func TestConsumeStoreFailure(t *testing.T) {
+	store := &failingStore{memStore: newMemStore()}
+	store.fail.Store(true)
+	source := brokertest.NewSource(2)
+	source.Produce("words", wordJSON(eventA, "apple", "яблоко"), wordJSON(eventB, "house", "дом"))
+	s := newConsumeService(t, config.Batch{}, store.memStore, source)
+	s.repo = store
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.consumeMessages(context.Background())
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for store.calls.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// The message is held, not skipped, while the database fails.
+	if got := source.Committed(); len(got) != 0 {
+		t.Fatalf("committed %d messages while the store fails", len(got))
+	}
+	store.fail.Store(false)
+	source.End()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer did not stop at the end of the source")
+	}
+
+	if got := strings.Join(store.stored(), ", "); got != "apple=яблоко, house=дом" {
+		t.Errorf("stored %s", got)
+	}
+	committed := source.Committed()
+	if len(committed) != 2 || string(committed[0].Value) != wordJSON(eventA, "apple", "яблоко") {
+		t.Errorf("committed %d messages, want both in order", len(committed))
+	}
+}
+
+func TestRunClosesSource(t *testing.T) {
+	source := brokertest.NewSource(0)
+	s := newConsumeService(t, config.Batch{}, newMemStore(), source)
+	s.server = server.New(s.logger, config.Server{Host: "127.0.0.1", Port: "0"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !source.Closed() {
+		t.Error("Run returned without closing the message source")
+	}
+}
+
+// Compile-time check that the Kafka consumer and the stand-in are message sources.
+var (
+	_ MessageSource = (*broker.Consumer)(nil)
+	_ MessageSource = (*brokertest.Source)(nil)
+)
@@ -0,0 +1,415 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/export"
+	"github.com/fentezi/export-word/internal/gmail"
+	"github.com/fentezi/export-word/internal/sink"
+	"github.com/fentezi/export-word/internal/smtptest"
+	"github.com/fentezi/export-word/internal/srs"
+	"github.com/fentezi/export-word/internal/workspace"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSender = "words@example.com"
+	// exportFile stands for the export file in the expected attachments; its name carries the
+	// time the digest is written.
+	exportFile = "<export>"
+)
+
+var testWords = []entity.MongoMessage{
+	{EventID: uuid.New(), Word: "apple", Translation: "яблоко"},
+	{EventID: uuid.New(), Word: "house", Translation: "дом"},
+	{EventID: uuid.New(), Word: "tree", Translation: "дерево"},
+}
+
+// newTestService returns a service that keeps its words and outbox in memory and mails
+// through the SMTP test server.
+func newTestService(t *testing.T, srv *smtptest.Server, cfg config.Config) *Service {
+	t.Helper()
+
+	cfg.Gmail = config.Gmail{Email: testSender, Password: "secret"}
+	cfg.Mail.Host, cfg.Mail.Port = srv.Host, srv.Port
+	cfg.Outbox = config.Outbox{MaxAttempts: 1, Lease: time.Minute}
+	email, err := gmail.New(cfg.Gmail, cfg.Mail)
+	if err != nil {
+		t.Fatalf("gmail.New: %v", err)
+	}
+	mail := sink.Mail{From: testSender, Mailer: &email}
+	sinks, err := newSinks(cfg, mail)
+	if err != nil {
+		t.Fatalf("newSinks: %v", err)
+	}
+	ws, err := workspace.New(config.Workspace{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Service{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		cfg:       cfg,
+		repo:      newMemStore(),
+		scheduler: srs.SM2{},
+		grade:     srs.Good,
+		mail:      mail,
+		workspace: ws,
+		sinks:     sinks,
+	}
+}
+
+// sendDigest stores the words and runs the export: the words are claimed and written to the
+// export file, the deliveries are queued and the outbox delivers them through the sinks of
+// every subscriber. It returns the digest ID and the name of the export file.
+func sendDigest(t *testing.T, s *Service, words []entity.MongoMessage) (string, string) {
+	t.Helper()
+
+	store := s.repo.(*memStore)
+	ctx := context.Background()
+	for _, w := range words {
+		if err := store.CreateWord(ctx, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.writeWordsToFileAndSend(ctx)
+
+	if len(store.outbox) == 0 {
+		t.Fatal("no deliveries queued")
+	}
+	for _, e := range store.outbox {
+		if e.Status != entity.OutboxDelivered {
+			t.Fatalf("delivery to %s is %s: %s", e.Recipient, e.Status, e.LastError)
+		}
+	}
+	if sent := store.sent(); sent != len(words) {
+		t.Errorf("%d of %d words marked as sent", sent, len(words))
+	}
+	entry := store.outbox[0]
+	return entry.DigestID, filepath.Base(entry.File)
+}
+
+func TestDigestEmail(t *testing.T) {
+	tests := []struct {
+		name        string
+		subscribers []config.Subscriber
+		bodyLimit   int
+		// body lists what the body of each recipient's message contains.
+		body  map[string][]string
+		files map[string][]string
+	}{
+		{
+			name:      "default recipient",
+			bodyLimit: 10,
+			body: map[string][]string{
+				testSender: {"apple", "яблоко", "house", "дом", "tree", "дерево"},
+			},
+			files: map[string][]string{testSender: {exportFile}},
+		},
+		{
+			name: "list and quiz subscribers",
+			subscribers: []config.Subscriber{
+				{Email: "list@example.com"},
+				{Email: "quiz@example.com", Mode: "quiz", Quiz: config.Quiz{
+					Direction: "forward", Prompts: 3, Answers: "attachment",
+				}},
+			},
+			bodyLimit: 10,
+			body: map[string][]string{
+				"list@example.com": {"apple", "яблоко", "tree", "дерево"},
+				"quiz@example.com": {"apple", "house", "tree"},
+			},
+			files: map[string][]string{
+				"list@example.com": {exportFile},
+				"quiz@example.com": {"answers.txt"},
+			},
+		},
+		{
+			name: "quiz subscriber with the export",
+			subscribers: []config.Subscriber{
+				{Email: "quiz@example.com", Mode: "quiz", Quiz: config.Quiz{
+					Prompts: 3, Answers: "attachment", Export: true,
+				}},
+			},
+			bodyLimit: 10,
+			body:      map[string][]string{"quiz@example.com": {"apple", "house", "tree"}},
+			files:     map[string][]string{"quiz@example.com": {exportFile, "answers.txt"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := smtptest.NewServer(t)
+			cfg := config.Config{Subscribers: tt.subscribers}
+			cfg.Export.Format = export.FormatCSV
+			cfg.Digest.BodyLimit = tt.bodyLimit
+			s := newTestService(t, srv, cfg)
+
+			digestID, fileName := sendDigest(t, s, testWords)
+
+			msgs := srv.WaitMessages(t, len(tt.body))
+			for _, msg := range msgs {
+				if len(msg.To) != 1 {
+					t.Fatalf("message to %v, want one recipient per message", msg.To)
+				}
+				to := msg.To[0]
+				want, ok := tt.body[to]
+				if !ok {
+					t.Errorf("unexpected message to %s", to)
+					continue
+				}
+				msg.AssertRecipients(t, to)
+				msg.AssertSubject(t, digestSubject)
+				msg.AssertBodyContains(t, "text/html", want...)
+				files := msg.Files()
+				for i, name := range files {
+					if name == fileName {
+						msg.AssertFile(t, name, []byte("apple;яблоко\nhouse;дом\ntree;дерево\n"))
+						files[i] = exportFile
+					}
+				}
+				if got := strings.Join(files, ","); got != strings.Join(tt.files[to], ",") {
+					t.Errorf("files to %s = %s, want %v", to, got, tt.files[to])
+				}
+				if id := msg.Header.Get("Message-ID"); !strings.HasPrefix(id, "<"+digestID+".") {
+					t.Errorf("Message-ID %q is not derived from the digest %s", id, digestID)
+				}
+				msg.AssertHeader(t, "References", "<"+digestID+"@example.com>")
+			}
+		})
+	}
+}
+
+func TestDigestEmailBodyLimit(t *testing.T) {
+	srv := smtptest.NewServer(t)
+	cfg := config.Config{}
+	cfg.Export.Format = export.FormatCSV
+	cfg.Digest.BodyLimit = 1
+	s := newTestService(t, srv, cfg)
+
+	_, fileName := sendDigest(t, s, testWords)
+
+	msg := srv.WaitMessages(t, 1)[0]
+	body, _ := msg.Body("text/html")
+	if strings.Contains(body, "дерево") {
+		t.Error("the body lists words beyond the limit")
+	}
+	// Every word is still in the attachment.
+	msg.AssertFile(t, fileName, []byte("apple;яблоко\nhouse;дом\ntree;дерево\n"))
+}
+
+func TestDigestEmailArchive(t *testing.T) {
+	srv := smtptest.NewServer(t)
+	cfg := config.Config{}
+	cfg.Export.Format = export.FormatCSV
+	cfg.Export.Formats = []string{export.FormatCSV, export.FormatMnemosyne}
+	cfg.Export.Archive = export.ArchiveZip
+	cfg.Digest.BodyLimit = 10
+	s := newTestService(t, srv, cfg)
+
+	_, fileName := sendDigest(t, s, testWords)
+
+	msg := srv.WaitMessages(t, 1)[0]
+	msg.AssertRecipients(t, testSender)
+	part, ok := msg.File(fileName)
+	if !ok {
+		t.Fatalf("no archive attached, files: %v", msg.Files())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(part.Data), int64(len(part.Data)))
+	if err != nil {
+		t.Fatalf("attachment is not a zip archive: %v", err)
+	}
+	entries := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+	for _, name := range []string{export.ManifestName, "words-csv.txt", "words-mnemosyne.xml"} {
+		if entries[name] == nil {
+			t.Errorf("archive lacks %s", name)
+		}
+	}
+	if f := entries["words-csv.txt"]; f != nil {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "apple;яблоко\nhouse;дом\ntree;дерево\n"; string(data) != want {
+			t.Errorf("words-csv.txt = %q, want %q", data, want)
+		}
+	}
+}
+
+// TestDigestSkipsUncoveredWords checks that a word the PDF has no glyphs for is left out of
+// the file without failing the digest.
+func TestDigestSkipsUncoveredWords(t *testing.T) {
+	store := newMemStore(
+		entity.MongoMessage{EventID: uuid.New(), Word: "apple", Translation: "苹果"},
+		entity.MongoMessage{EventID: uuid.New(), Word: "house", Translation: "дом"},
+	)
+	s := newOutboxService(t, store, &stubSink{name: "a"})
+	s.cfg.Export.Format = export.FormatPDF
+	s.cfg.Export.PDF = config.PDF{PageSize: "A4", Columns: 2, Rows: 4, MaxCards: 100}
+
+	s.writeWordsToFileAndSend(context.Background())
+
+	if len(store.outbox) != 1 || store.outbox[0].Status != entity.OutboxDelivered {
+		t.Fatalf("outbox = %+v, want one delivered entry", store.outbox)
+	}
+	if sent := store.sent(); sent != 2 {
+		t.Errorf("%d of 2 words marked as sent", sent)
+	}
+}
+
+// discardSink accepts every delivery without looking at it.
+type discardSink struct{}
+
+func (discardSink) Name() string {
+	return "discard"
+}
+
+func (discardSink) Deliver(context.Context, sink.Delivery) error {
+	return nil
+}
+
+// benchWords returns n distinct words due now.
+func benchWords(n int) []entity.MongoMessage {
+	words := make([]entity.MongoMessage, n)
+	for i := range words {
+		var id uuid.UUID
+		binary.BigEndian.PutUint64(id[8:], uint64(i))
+		words[i] = entity.MongoMessage{
+			EventID:     id,
+			Word:        "word" + strconv.Itoa(i),
+			Translation: "слово" + strconv.Itoa(i),
+		}
+	}
+	return words
+}
+
+// maxExportHeap bounds the heap an export holds while it runs, whatever the backlog. It leaves
+// room for the buffer excelize keeps before spilling a sheet to a temporary file.
+const maxExportHeap = 64 << 20
+
+// BenchmarkWriteWordsToFile exports a backlog of unsent words from the in-memory store: the
+// words are claimed, streamed from the store into the export file and the email body, the
+// digest is delivered through the outbox and its words are marked as sent. Only the store
+// holds the words, so the heap the export holds on top of it, measured on one more run outside
+// the timing and reported as heap-B, stays under maxExportHeap as the backlog grows.
+func BenchmarkWriteWordsToFile(b *testing.B) {
+	for _, format := range []string{export.FormatCSV, export.FormatXLSX} {
+		for _, n := range []int{10_000, 1_000_000} {
+			b.Run(format+"/"+strconv.Itoa(n), func(b *testing.B) {
+				benchmarkExport(b, format, n)
+			})
+		}
+	}
+}
+
+func benchmarkExport(b *testing.B, format string, n int) {
+	store := newMemStore(benchWords(n)...)
+	ws, err := workspace.New(config.Workspace{Dir: b.TempDir()})
+	if err != nil {
+		b.Fatal(err)
+	}
+	cfg := config.Config{Subscribers: []config.Subscriber{
+		{Email: testSender, Sinks: []string{"discard"}},
+	}}
+	cfg.Export.Format = format
+	cfg.Digest.BodyLimit = 20
+	cfg.Outbox = config.Outbox{MaxAttempts: 1, Lease: time.Minute}
+	s := &Service{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		cfg:       cfg,
+		repo:      store,
+		scheduler: srs.SM2{},
+		grade:     srs.Good,
+		workspace: ws,
+		sinks:     map[string]sink.Sink{"discard": discardSink{}},
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		b.StopTimer()
+		store.unsend()
+		b.StartTimer()
+
+		s.writeWordsToFileAndSend(ctx)
+	}
+	b.StopTimer()
+
+	if sent := store.sent(); sent != n {
+		b.Fatalf("%d of %d words marked as sent", sent, n)
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/float64(n), "ns/word")
+
+	store.unsend()
+	held := heapHeld(ctx, s, store, n)
+	b.ReportMetric(float64(held), "heap-B")
+	if held > maxExportHeap {
+		b.Errorf("export held %d MiB of heap, want at most %d", held>>20, maxExportHeap>>20)
+	}
+}
+
+// sampledStore calls sample every so many words it streams into a digest. The sample runs on
+// the goroutine of the export, so nothing allocates while it measures the heap.
+type sampledStore struct {
+	*memStore
+	every  int
+	sample func()
+}
+
+func (s sampledStore) StreamDigest(
+	ctx context.Context, digestID string, fn func(entity.MongoMessage) error,
+) error {
+	i := 0
+	return s.memStore.StreamDigest(ctx, digestID, func(w entity.MongoMessage) error {
+		if i++; i%s.every == 0 {
+			s.sample()
+		}
+		return fn(w)
+	})
+}
+
+// heapHeld exports the n words of the store and returns how far the live heap rose during the
+// export above the heap it leaves behind: the memory the export held only while it ran, not
+// what it added to the store.
+func heapHeld(ctx context.Context, s *Service, store *memStore, n int) uint64 {
+	var top uint64
+	s.repo = sampledStore{memStore: store, every: max(1, n/20), sample: func() {
+		top = max(top, liveHeap())
+	}}
+	defer func() { s.repo = store }()
+
+	s.writeWordsToFileAndSend(ctx)
+
+	if after := liveHeap(); top > after {
+		return top - after
+	}
+	return 0
+}
+
+func liveHeap() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
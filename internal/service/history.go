@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/server"
+	"github.com/fentezi/export-word/internal/sink"
+	"log/slog"
+	"time"
+)
+
+const defaultDigestLimit = 20
+
+// Digests returns the latest digests, newest first, 20 when limit is not positive.
+func (s *Service) Digests(ctx context.Context, limit int) ([]entity.Digest, error) {
+	if limit <= 0 {
+		limit = defaultDigestLimit
+	}
+	digests, err := s.repo.ListDigests(ctx, int64(limit))
+	if err != nil {
+		return nil, fmt.Errorf("service.Digests: %w", err)
+	}
+	return digests, nil
+}
+
+// Digest returns the digest and its deliveries, resends included.
+func (s *Service) Digest(
+	ctx context.Context,
+	digestID string,
+) (entity.Digest, []entity.OutboxEntry, error) {
+	const op = "service.Digest"
+
+	d, err := s.repo.GetDigest(ctx, digestID)
+	if err != nil {
+		return entity.Digest{}, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	deliveries, err := s.repo.ListOutboxEntries(ctx, digestID)
+	if err != nil {
+		return entity.Digest{}, nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return d, deliveries, nil
+}
+
+// Resend rebuilds the attachment of a past digest in its original format from the recorded
+// words and queues its delivery to the recipients, the original ones when empty. Configured
+// subscribers keep their digest mode and sinks; sinks, when given, replace the sinks of every
+// recipient. The words are not rescheduled. It returns the number of queued deliveries, which
+// the outbox worker or DeliverOutbox sends.
+func (s *Service) Resend(
+	ctx context.Context,
+	digestID string,
+	to []string,
+	sinks []string,
+) (int, error) {
+	const op = "service.Resend"
+
+	d, err := s.repo.GetDigest(ctx, digestID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(to) == 0 {
+		to = d.Recipients
+	}
+
+	subscribers := make([]config.Subscriber, 0, len(to))
+	for _, email := range to {
+		sub := s.subscriber(email)
+		if len(sinks) > 0 {
+			sub.Sinks = sinks
+		}
+		for _, name := range sub.SinkList() {
+			if _, ok := s.sinks[name]; !ok {
+				return 0, fmt.Errorf("%s: %w: %q", op, sink.ErrUnknownSink, name)
+			}
+		}
+		subscribers = append(subscribers, sub)
+	}
+
+	now := time.Now()
+	exp, err := s.workspace.Create(now)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	release := func() {
+		if err := s.workspace.Release(exp); err != nil {
+			s.logger.Error("failed to remove export directory", "error", err)
+		}
+	}
+
+	cfg := s.cfg.Export
+	cfg.Format, cfg.Formats, cfg.Archive = d.Format, d.Formats, d.Archive
+	fileName := exportPath(exp, cfg)
+	file, err := initFile(fileName)
+	if err != nil {
+		release()
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	exporter, err := s.newExporter(cfg, d.ID, exp.Dir, file)
+	if err != nil {
+		file.Close()
+		release()
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	count, contents, err := s.renderDigest(
+		d.ID, exporter, subscribers, now,
+		func(fn func(entity.MongoMessage) error) error {
+			return s.repo.StreamDigestWords(ctx, d.ID, fn)
+		},
+	)
+	file.Close()
+	if err == nil && count == 0 {
+		err = server.ErrEmptyDigest
+	}
+	queued := 0
+	if err == nil {
+		queued, err = s.enqueue(ctx, d.ID, fileName, count, subscribers, contents, true, now)
+	}
+	if err != nil {
+		release()
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.logger.Info(
+		"digest resent", slog.String("digest_id", d.ID), slog.Any("to", to),
+		slog.Int("deliveries", queued),
+	)
+	return queued, nil
+}
+
+// DeliverOutbox delivers the due outbox entries until none is left.
+func (s *Service) DeliverOutbox(ctx context.Context) {
+	s.processOutbox(ctx)
+}
+
+// subscriber returns the configured subscriber with the email address, or a subscriber with
+// the default list digest.
+func (s *Service) subscriber(email string) config.Subscriber {
+	for _, sub := range s.cfg.SubscriberList() {
+		if sub.Email == email {
+			return sub
+		}
+	}
+	return config.Subscriber{Email: email}
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/digest"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/repository"
+	"github.com/fentezi/export-word/internal/sink"
+	"github.com/fentezi/export-word/internal/workspace"
+	"github.com/google/uuid"
+	"log/slog"
+	"math/rand/v2"
+	"path/filepath"
+	"time"
+)
+
+// errEnoughWords stops streaming a digest once the words for a delivery are collected.
+var errEnoughWords = errors.New("enough words")
+
+// enqueue stores one outbox entry per sink of every subscriber and one per shared sink, and
+// returns the number of entries. contents holds the rendered email of each subscriber; resend
+// marks the entries as a resend of the digest, which does not reschedule its words.
+func (s *Service) enqueue(
+	ctx context.Context,
+	digestID, file string,
+	count int,
+	subscribers []config.Subscriber,
+	contents []digest.Content,
+	resend bool,
+	now time.Time,
+) (int, error) {
+	var entries []entity.OutboxEntry
+	// shared maps the shared sinks to their entry, which leaves out the answers and the file
+	// when any of their subscribers does not receive them.
+	shared := make(map[string]int)
+	for i, sub := range subscribers {
+		quiz := sub.Mode == digest.ModeQuiz
+		var attachments []entity.Attachment
+		for _, a := range contents[i].Attachments {
+			attachments = append(attachments, entity.Attachment{Name: a.Name, Data: a.Data})
+		}
+		for _, name := range sub.SinkList() {
+			if j, ok := shared[name]; ok {
+				entries[j].Quiz = entries[j].Quiz || quiz
+				entries[j].OmitFile = entries[j].OmitFile || !sub.AttachExport()
+				continue
+			}
+			entry := entity.OutboxEntry{
+				ID:            uuid.NewString(),
+				DigestID:      digestID,
+				Recipient:     sub.Email,
+				Sink:          name,
+				Subject:       digestSubject,
+				File:          file,
+				OmitFile:      !sub.AttachExport(),
+				Count:         count,
+				HTML:          contents[i].HTML,
+				Attachments:   attachments,
+				Resend:        resend,
+				Status:        entity.OutboxPending,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			}
+			if sink.Shared(s.sinks[name]) {
+				shared[name] = len(entries)
+				entry.Recipient, entry.HTML, entry.Attachments = "", "", nil
+				entry.Quiz = quiz
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	if err := s.repo.CreateOutboxEntries(ctx, entries); err != nil {
+		s.logger.Error(
+			"failed to queue deliveries", slog.String("error", err.Error()),
+			slog.String("digest_id", digestID),
+		)
+		return 0, err
+	}
+	s.logger.Debug(
+		"deliveries queued", slog.String("digest_id", digestID), slog.Int("count", len(entries)),
+	)
+	return len(entries), nil
+}
+
+// runOutbox delivers the due outbox entries, including the ones left over from a previous run,
+// every poll interval until the context is done.
+func (s *Service) runOutbox(ctx context.Context) {
+	for {
+		s.processOutbox(ctx)
+		select {
+		case <-ctx.Done():
+			s.logger.Info("stop outbox worker")
+			return
+		case <-time.After(s.cfg.Outbox.PollInterval):
+		}
+	}
+}
+
+// processOutbox delivers due entries one at a time until none is left.
+func (s *Service) processOutbox(ctx context.Context) {
+	for ctx.Err() == nil {
+		entry, err := s.repo.ClaimOutboxEntry(ctx, time.Now(), s.cfg.Outbox.Lease)
+		if err != nil {
+			if !errors.Is(err, repository.ErrDocumentNotFound) {
+				s.logger.Error("failed to claim delivery", slog.String("error", err.Error()))
+			}
+			return
+		}
+		s.deliverEntry(ctx, entry)
+	}
+}
+
+// deliverEntry hands the entry to its sink and records the outcome. A failed attempt is
+// retried with backoff until the attempts run out, a deferred one once its window opens.
+func (s *Service) deliverEntry(ctx context.Context, entry entity.OutboxEntry) {
+	log := s.logger.With(
+		slog.String("digest_id", entry.DigestID), slog.String("sink", entry.Sink),
+		slog.String("to", entry.Recipient),
+	)
+
+	err := s.send(ctx, entry)
+	now := time.Now()
+	var deferred *sink.DeferredError
+	if errors.As(err, &deferred) {
+		// Waiting for a rate limit or quota window is not a failed attempt.
+		entry.NextAttemptAt = deferred.Until
+		log.Info(
+			"delivery deferred", slog.String("reason", deferred.Reason),
+			slog.Time("next_attempt", entry.NextAttemptAt),
+		)
+		if err := s.repo.UpdateOutboxEntry(ctx, entry); err != nil {
+			log.Error("failed to update delivery", slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	entry.Attempts++
+	switch {
+	case err == nil:
+		entry.Status = entity.OutboxDelivered
+		entry.DeliveredAt = now
+		entry.LastError = ""
+		log.Info("digest delivered", slog.Int("attempts", entry.Attempts))
+	case entry.Attempts >= s.cfg.Outbox.MaxAttempts:
+		entry.Status = entity.OutboxFailed
+		entry.LastError = err.Error()
+		log.Error(
+			"giving up on delivery", slog.String("error", err.Error()),
+			slog.Int("attempts", entry.Attempts),
+		)
+	default:
+		entry.NextAttemptAt = now.Add(backoff(s.cfg.Outbox, entry.Attempts))
+		entry.LastError = err.Error()
+		log.Warn(
+			"failed to deliver digest, retrying", slog.String("error", err.Error()),
+			slog.Int("attempts", entry.Attempts), slog.Time("next_attempt", entry.NextAttemptAt),
+		)
+	}
+
+	if err := s.repo.UpdateOutboxEntry(ctx, entry); err != nil {
+		// The lease expires and the entry is attempted again.
+		log.Error("failed to update delivery", slog.String("error", err.Error()))
+		return
+	}
+
+	if entry.Status != entity.OutboxPending {
+		s.finishDigest(ctx, entry)
+	}
+}
+
+// send rebuilds the delivery of the entry and hands it to its sink.
+func (s *Service) send(ctx context.Context, entry entity.OutboxEntry) error {
+	sk, ok := s.sinks[entry.Sink]
+	if !ok {
+		return fmt.Errorf("%w: %q", sink.ErrUnknownSink, entry.Sink)
+	}
+	d, err := s.delivery(ctx, entry)
+	if err != nil {
+		return err
+	}
+	return sk.Deliver(ctx, d)
+}
+
+// delivery rebuilds the delivery of the entry with the first words recorded for the digest
+// and a stream of all of them.
+func (s *Service) delivery(ctx context.Context, entry entity.OutboxEntry) (sink.Delivery, error) {
+	d := sink.Delivery{
+		DigestID:  entry.DigestID,
+		Recipient: entry.Recipient,
+		Subject:   entry.Subject,
+		Count:     entry.Count,
+		Content:   digest.Content{HTML: entry.HTML},
+		Quiz:      entry.Quiz,
+		Stream: func(fn func(entity.MongoMessage) error) error {
+			return s.repo.StreamDigestWords(ctx, entry.DigestID, fn)
+		},
+		CreatedAt: entry.CreatedAt,
+	}
+	if !entry.OmitFile {
+		d.File = entry.File
+	}
+	for _, a := range entry.Attachments {
+		d.Content.Attachments = append(
+			d.Content.Attachments, digest.Attachment{Name: a.Name, Data: a.Data},
+		)
+	}
+
+	limit := s.cfg.Digest.BodyLimit
+	err := s.repo.StreamDigestWords(ctx, entry.DigestID, func(word entity.MongoMessage) error {
+		if len(d.Words) >= limit {
+			return errEnoughWords
+		}
+		d.Words = append(d.Words, word)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errEnoughWords) {
+		return sink.Delivery{}, err
+	}
+	return d, nil
+}
+
+// finishDigest completes the digest of the entry. Once none of the digest's own entries is
+// pending and any of them was delivered, the words are scheduled for their next review with
+// the default grade and marked as sent; when all of them failed, the words stay due and unsent
+// and go out with the next digest. Resends never change the words. The export directories of
+// the entries, the digest's and those of its resends, are released once none is pending.
+func (s *Service) finishDigest(ctx context.Context, entry entity.OutboxEntry) {
+	log := s.logger.With(slog.String("digest_id", entry.DigestID))
+	entries, err := s.repo.ListOutboxEntries(ctx, entry.DigestID)
+	if err != nil {
+		log.Error("failed to list deliveries", slog.String("error", err.Error()))
+		return
+	}
+
+	var pending, own, delivered bool
+	for _, e := range entries {
+		switch {
+		case e.Status == entity.OutboxPending:
+			pending = true
+			own = own || !e.Resend
+		case e.Status == entity.OutboxDelivered && !e.Resend:
+			delivered = true
+		}
+	}
+	if !pending {
+		defer s.releaseExports(log, entries)
+	}
+	// The last of the digest's own entries to finish completes it.
+	if entry.Resend || own {
+		return
+	}
+	if !delivered {
+		log.Warn("every delivery failed, words stay due")
+		return
+	}
+
+	now := time.Now()
+	schedule := func(word entity.MongoMessage) entity.SRS {
+		return s.scheduler.Schedule(word.SRS, s.grade, now)
+	}
+	if _, err := s.repo.RescheduleDigest(ctx, entry.DigestID, schedule); err != nil {
+		log.Error("failed to reschedule words", slog.String("error", err.Error()))
+		return
+	}
+	if _, err := s.repo.MarkDigestSent(ctx, entry.DigestID); err != nil {
+		log.Error("failed to mark words as sent", slog.String("error", err.Error()))
+	}
+}
+
+// releaseExports releases the export directory of every entry once.
+func (s *Service) releaseExports(log *slog.Logger, entries []entity.OutboxEntry) {
+	released := make(map[string]bool)
+	for _, e := range entries {
+		if e.File == "" {
+			continue
+		}
+		dir := filepath.Dir(e.File)
+		if released[dir] {
+			continue
+		}
+		released[dir] = true
+		if err := s.workspace.Release(workspace.Export{Dir: dir}); err != nil {
+			log.Error("failed to remove export directory", "error", err)
+		}
+	}
+}
+
+// backoff returns the delay before the attempt after the given one: BaseDelay doubled per
+// attempt up to MaxDelay, with up to half of it taken off at random so that failing entries
+// spread out.
+func backoff(cfg config.Outbox, attempts int) time.Duration {
+	d := cfg.BaseDelay << min(attempts-1, 30)
+	if d <= 0 || d > cfg.MaxDelay {
+		d = cfg.MaxDelay
+	}
+	return d - rand.N(d/2+1)
+}
@@ -0,0 +1,374 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/digest"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/export"
+	"github.com/fentezi/export-word/internal/review"
+	"github.com/fentezi/export-word/internal/sink"
+	"github.com/fentezi/export-word/internal/srs"
+	"github.com/fentezi/export-word/internal/workspace"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// stubSink fails every delivery with err, or accepts it when err is nil. It records the
+// deliveries handed to it.
+type stubSink struct {
+	name       string
+	err        error
+	shared     bool
+	deliveries []sink.Delivery
+}
+
+func (s *stubSink) Name() string {
+	return s.name
+}
+
+func (s *stubSink) Shared() bool {
+	return s.shared
+}
+
+func (s *stubSink) Deliver(_ context.Context, d sink.Delivery) error {
+	s.deliveries = append(s.deliveries, d)
+	return s.err
+}
+
+// newOutboxService returns a service that exports the words in store through the named stub
+// sinks of a single subscriber, giving up on a delivery after its first failed attempt.
+func newOutboxService(t *testing.T, store *memStore, sinks ...*stubSink) *Service {
+	t.Helper()
+
+	ws, err := workspace.New(config.Workspace{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := config.Subscriber{Email: testSender}
+	byName := make(map[string]sink.Sink, len(sinks))
+	for _, sk := range sinks {
+		sub.Sinks = append(sub.Sinks, sk.name)
+		byName[sk.name] = sk
+	}
+	cfg := config.Config{Subscribers: []config.Subscriber{sub}}
+	cfg.Export.Format = export.FormatCSV
+	cfg.Digest.BodyLimit = 10
+	cfg.Outbox = config.Outbox{MaxAttempts: 1, Lease: time.Minute}
+	return &Service{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		cfg:       cfg,
+		repo:      store,
+		scheduler: srs.SM2{},
+		grade:     srs.Good,
+		workspace: ws,
+		sinks:     byName,
+	}
+}
+
+// deferred defers a delivery past the end of the test.
+var deferred = &sink.DeferredError{Until: time.Now().Add(time.Hour), Reason: "quota"}
+
+func TestDigestOutcome(t *testing.T) {
+	failed := errors.New("unreachable")
+	tests := []struct {
+		name  string
+		sinks []*stubSink
+		// done reports whether the words are rescheduled and sent; otherwise they stay due,
+		// unsent and are claimed by the next digest unless a delivery is pending.
+		done    bool
+		pending bool
+	}{
+		{name: "delivered", sinks: []*stubSink{{name: "a"}}, done: true},
+		{name: "every delivery failed", sinks: []*stubSink{{name: "a", err: failed}}},
+		{
+			name:  "one of two delivered",
+			sinks: []*stubSink{{name: "a"}, {name: "b", err: failed}},
+			done:  true,
+		},
+		{
+			name:    "a delivery is still pending",
+			sinks:   []*stubSink{{name: "a"}, {name: "b", err: deferred}},
+			pending: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemStore(benchWords(3)...)
+			s := newOutboxService(t, store, tt.sinks...)
+			ctx := context.Background()
+
+			s.writeWordsToFileAndSend(ctx)
+
+			digestID := store.words[0].DigestID
+			for _, w := range store.words {
+				if w.Sent != tt.done {
+					t.Errorf("%s sent = %v, want %v", w.Word, w.Sent, tt.done)
+				}
+				if rescheduled := w.ScheduledBy == digestID; rescheduled != tt.done {
+					t.Errorf("%s rescheduled = %v, want %v", w.Word, rescheduled, tt.done)
+				}
+			}
+			claimed, err := store.ClaimWords(ctx, "next", srs.DueBy(time.Now()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := int64(len(store.words))
+			if tt.done || tt.pending {
+				want = 0
+			}
+			if claimed != want {
+				t.Errorf("next digest claimed %d words, want %d", claimed, want)
+			}
+		})
+	}
+}
+
+// TestRetriedDigestKeepsItsWords runs a digest cycle while the delivery of the previous
+// digest is being retried: its words are not claimed again, so they are delivered and
+// rescheduled once, by the digest they were first sent with.
+func TestRetriedDigestKeepsItsWords(t *testing.T) {
+	store := newMemStore(benchWords(3)...)
+	failing := &stubSink{name: "a", err: errors.New("unreachable")}
+	s := newOutboxService(t, store, failing)
+	s.cfg.Outbox.MaxAttempts = 3
+	s.cfg.Outbox.BaseDelay, s.cfg.Outbox.MaxDelay = time.Hour, time.Hour
+	ctx := context.Background()
+
+	s.writeWordsToFileAndSend(ctx)
+	first := store.words[0].DigestID
+	s.writeWordsToFileAndSend(ctx)
+
+	if len(store.outbox) != 1 {
+		t.Fatalf("%d deliveries queued, want the retried one only", len(store.outbox))
+	}
+	for _, w := range store.words {
+		if w.DigestID != first {
+			t.Errorf("%s claimed by digest %s, want %s", w.Word, w.DigestID, first)
+		}
+	}
+
+	// The retry succeeds.
+	failing.err = nil
+	store.outbox[0].NextAttemptAt = time.Time{}
+	s.processOutbox(ctx)
+	for _, w := range store.words {
+		if !w.Sent || w.ScheduledBy != first {
+			t.Errorf("%s sent = %v by %s, want sent by %s", w.Word, w.Sent, w.ScheduledBy, first)
+		}
+	}
+	if got := len(failing.deliveries); got != 2 {
+		t.Errorf("%d attempts, want 2", got)
+	}
+}
+
+// TestDigestRescheduledOnce checks that finishing a digest again, as a resend does, and a
+// review given before the digest was delivered are not overridden by the default grade.
+func TestDigestRescheduledOnce(t *testing.T) {
+	store := newMemStore(benchWords(2)...)
+	pending := &stubSink{name: "a", err: deferred}
+	s := newOutboxService(t, store, pending)
+	ctx := context.Background()
+
+	s.writeWordsToFileAndSend(ctx)
+	reviewed, other := store.words[0], store.words[1]
+	claims := review.Claims{
+		EventID: reviewed.EventID, DigestID: reviewed.DigestID, Grade: srs.Easy,
+	}
+	if err := s.Review(ctx, claims); err != nil {
+		t.Fatalf("Review: %v", err)
+	}
+
+	// The deferral ends and the digest is delivered.
+	pending.err = nil
+	store.outbox[0].NextAttemptAt = time.Time{}
+	s.processOutbox(ctx)
+	if store.words[0].Reviews[0].After != store.words[0].SRS {
+		t.Errorf(
+			"reviewed word scheduled %+v, want the review %+v",
+			store.words[0].SRS, store.words[0].Reviews[0].After,
+		)
+	}
+	if !store.words[1].Sent || store.words[1].SRS == other.SRS {
+		t.Fatalf("word not sent and rescheduled: %+v", store.words[1])
+	}
+
+	scheduled := store.words[1].SRS
+	s.finishDigest(ctx, store.outbox[0])
+	if store.words[1].SRS != scheduled {
+		t.Errorf("finishing again rescheduled the word to %+v", store.words[1].SRS)
+	}
+}
+
+// TestSharedSinkOncePerDigest checks that a sink the subscribers share, such as a group chat,
+// gets the digest once and without the answers when it is a quiz to one of them.
+// TestResendKeepsWords checks that resending a digest, delivered or failed, leaves the
+// spaced-repetition state and the sent flag of its words as they are.
+func TestResendKeepsWords(t *testing.T) {
+	for _, failed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("failed=%v", failed), func(t *testing.T) {
+			store := newMemStore(benchWords(3)...)
+			sk := &stubSink{name: "a"}
+			if failed {
+				sk.err = errors.New("unreachable")
+			}
+			s := newOutboxService(t, store, sk)
+			ctx := context.Background()
+
+			s.writeWordsToFileAndSend(ctx)
+			before := slices.Clone(store.words)
+			sk.err = nil
+			if _, err := s.Resend(ctx, before[0].DigestID, nil, nil); err != nil {
+				t.Fatalf("Resend: %v", err)
+			}
+			s.processOutbox(ctx)
+
+			if got := store.outbox[len(store.outbox)-1].Status; got != entity.OutboxDelivered {
+				t.Fatalf("resend is %s, want delivered", got)
+			}
+			for i, w := range store.words {
+				if !reflect.DeepEqual(w, before[i]) {
+					t.Errorf("resend changed %s from %+v to %+v", w.Word, before[i], w)
+				}
+			}
+		})
+	}
+}
+
+// TestFinishedDigestReleasesEveryExport checks that a digest resent while its first
+// delivery was deferred releases both export directories once neither is pending.
+func TestFinishedDigestReleasesEveryExport(t *testing.T) {
+	store := newMemStore(benchWords(2)...)
+	pending := &stubSink{name: "a", err: deferred}
+	s := newOutboxService(t, store, pending)
+	ctx := context.Background()
+
+	s.writeWordsToFileAndSend(ctx)
+	if _, err := s.Resend(ctx, store.words[0].DigestID, nil, nil); err != nil {
+		t.Fatalf("Resend: %v", err)
+	}
+	if len(store.outbox) != 2 || store.outbox[0].File == store.outbox[1].File {
+		t.Fatalf("deliveries = %+v, want two with their own export", store.outbox)
+	}
+
+	pending.err = nil
+	for i := range store.outbox {
+		store.outbox[i].NextAttemptAt = time.Time{}
+	}
+	s.processOutbox(ctx)
+
+	for _, e := range store.outbox {
+		if e.Status != entity.OutboxDelivered {
+			t.Errorf("delivery %s is %s", e.ID, e.Status)
+		}
+		if _, err := os.Stat(filepath.Dir(e.File)); !os.IsNotExist(err) {
+			t.Errorf("export %s kept: %v", filepath.Dir(e.File), err)
+		}
+	}
+}
+
+func TestSharedSinkOncePerDigest(t *testing.T) {
+	store := newMemStore(benchWords(3)...)
+	chat := &stubSink{name: "chat", shared: true}
+	s := newOutboxService(t, store, chat, &stubSink{name: "email"})
+	s.cfg.Subscribers = []config.Subscriber{
+		{Email: "list@example.com", Sinks: []string{"email", "chat"}},
+		{Email: "quiz@example.com", Mode: digest.ModeQuiz, Sinks: []string{"chat", "email"}},
+	}
+
+	s.writeWordsToFileAndSend(context.Background())
+
+	if n := len(store.outbox); n != 3 {
+		t.Fatalf("%d deliveries queued, want an email per subscriber and one chat", n)
+	}
+	if len(chat.deliveries) != 1 {
+		t.Fatalf("chat got %d deliveries, want 1", len(chat.deliveries))
+	}
+	d := chat.deliveries[0]
+	if d.Recipient != "" || !d.Quiz || d.File != "" || d.Content.HTML != "" {
+		t.Errorf("chat delivery = %+v, want no recipient, answers, file or email body", d)
+	}
+	if len(d.Words) != 3 {
+		t.Errorf("chat delivery has %d words, want 3", len(d.Words))
+	}
+}
+
+// TestDeliveryStreamsWholeDigest checks that sinks sampling the digest get all of its words,
+// not only the ones cut to the body limit.
+func TestDeliveryStreamsWholeDigest(t *testing.T) {
+	store := newMemStore(benchWords(25)...)
+	chat := &stubSink{name: "chat", shared: true}
+	s := newOutboxService(t, store, chat)
+	ctx := context.Background()
+
+	s.writeWordsToFileAndSend(ctx)
+
+	if len(chat.deliveries) != 1 {
+		t.Fatalf("chat got %d deliveries, want 1", len(chat.deliveries))
+	}
+	d := chat.deliveries[0]
+	streamed := 0
+	if err := d.Stream(func(entity.MongoMessage) error { streamed++; return nil }); err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if len(d.Words) != s.cfg.Digest.BodyLimit || streamed != 25 {
+		t.Errorf("%d words and %d streamed, want 10 and 25", len(d.Words), streamed)
+	}
+}
+
+func TestPruneKeepsPendingExports(t *testing.T) {
+	ws, err := workspace.New(config.Workspace{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	// An export whose delivery is pending, deferred or retried, one that was delivered and
+	// one left behind by a crash, all older than a day.
+	dirs := make(map[string]string)
+	for _, name := range []string{"pending", "delivered", "crashed"} {
+		exp, err := ws.Create(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(exp.Path(wordsFileName, "txt"), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(exp.Dir, old, old); err != nil {
+			t.Fatal(err)
+		}
+		dirs[name] = exp.Dir
+	}
+	store := newMemStore()
+	store.outbox = []entity.OutboxEntry{
+		{ID: "1", File: filepath.Join(dirs["pending"], "words.txt"), Status: entity.OutboxPending},
+		{
+			ID: "2", File: filepath.Join(dirs["delivered"], "words.txt"),
+			Status: entity.OutboxDelivered,
+		},
+	}
+	s := &Service{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		repo:      store,
+		workspace: ws,
+	}
+
+	s.pruneExports(context.Background(), now)
+
+	for name, want := range map[string]bool{"pending": true, "delivered": false, "crashed": false} {
+		_, err := os.Stat(dirs[name])
+		if got := err == nil; got != want {
+			t.Errorf("%s export kept = %v, want %v", name, got, want)
+		}
+	}
+}
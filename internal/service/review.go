@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/repository"
+	"github.com/fentezi/export-word/internal/review"
+	"github.com/fentezi/export-word/internal/server"
+	"log/slog"
+	"time"
+)
+
+// Review applies the grade of a verified review link to the word. The grade replaces the
+// implicit one applied once the digest was delivered, so answering twice keeps the last
+// answer; a word the digest has not scheduled yet is graded from its current state.
+func (s *Service) Review(ctx context.Context, claims review.Claims) error {
+	const op = "service.Review"
+
+	word, err := s.repo.GetWordByEventID(ctx, claims.EventID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if word.DigestID != claims.DigestID {
+		return fmt.Errorf("%s: %w", op, server.ErrStaleReview)
+	}
+
+	before := word.SRS
+	// Words scheduled before the digest recorded ScheduledBy have only PrevSRS.
+	if word.PrevSRS != nil && (word.ScheduledBy == claims.DigestID || word.ScheduledBy == "") {
+		before = *word.PrevSRS
+	}
+	now := time.Now()
+	after := s.scheduler.Schedule(before, claims.Grade, now)
+
+	rv := entity.Review{
+		EventID:    claims.EventID,
+		DigestID:   claims.DigestID,
+		Grade:      claims.Grade.String(),
+		Before:     before,
+		After:      after,
+		ReviewedAt: now.UTC(),
+	}
+	if err := s.repo.ApplyReview(ctx, rv); err != nil {
+		if errors.Is(err, repository.ErrDocumentNotFound) {
+			// The word was claimed by a newer digest or deleted since it was read.
+			return fmt.Errorf("%s: %w", op, server.ErrStaleReview)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.logger.Info(
+		"word reviewed", slog.String("word", word.Word), slog.String("grade", rv.Grade),
+		slog.Time("due", after.Due),
+	)
+	return nil
+}
@@ -2,31 +2,109 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/digest"
 	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/export"
 	"github.com/fentezi/export-word/internal/gmail"
 	"github.com/fentezi/export-word/internal/kafka"
+	"github.com/fentezi/export-word/internal/mapping"
+	"github.com/fentezi/export-word/internal/pgp"
+	"github.com/fentezi/export-word/internal/ratelimit"
 	"github.com/fentezi/export-word/internal/repository"
+	"github.com/fentezi/export-word/internal/review"
+	"github.com/fentezi/export-word/internal/server"
+	"github.com/fentezi/export-word/internal/sink"
+	"github.com/fentezi/export-word/internal/srs"
+	"github.com/fentezi/export-word/internal/stats"
+	"github.com/fentezi/export-word/internal/workspace"
+	"github.com/google/uuid"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
 const (
-	wordsFileName = "words.txt"
+	wordsFileName = "words"
+	digestSubject = "Dictionary"
 )
 
+// MessageSource delivers the messages words are read from. Fetch blocks until the next message
+// arrives or ctx is done and returns io.EOF once the source is closed; Commit marks messages as
+// consumed after they are stored.
+type MessageSource interface {
+	Fetch(ctx context.Context) (broker.Message, error)
+	Commit(ctx context.Context, msgs ...broker.Message) error
+	Close() error
+}
+
+// WordStore is the part of the repository incoming words are stored in.
+type WordStore interface {
+	GetWordByEventID(ctx context.Context, eventID uuid.UUID) (entity.MongoMessage, error)
+	CreateWord(ctx context.Context, msg entity.MongoMessage) error
+	UpsertWords(ctx context.Context, msgs []entity.MongoMessage) (int64, error)
+}
+
+// Store is the part of the repository the service keeps words, reviews, the digest history
+// and the outbox in.
+type Store interface {
+	WordStore
+
+	ClaimWords(ctx context.Context, digestID string, dueBy time.Time) (int64, error)
+	StreamDigest(ctx context.Context, digestID string, fn func(entity.MongoMessage) error) error
+	StreamWords(ctx context.Context, fn func(entity.MongoMessage) error) error
+	MarkDigestSent(ctx context.Context, digestID string) (int64, error)
+	RescheduleDigest(
+		ctx context.Context, digestID string, fn func(entity.MongoMessage) entity.SRS,
+	) (int64, error)
+	ApplyReview(ctx context.Context, review entity.Review) error
+
+	CreateDigest(ctx context.Context, digest entity.Digest) error
+	AddDigestWords(ctx context.Context, digestID string, seq int, eventIDs []uuid.UUID) error
+	ListDigests(ctx context.Context, limit int64) ([]entity.Digest, error)
+	GetDigest(ctx context.Context, digestID string) (entity.Digest, error)
+	StreamDigestWords(
+		ctx context.Context, digestID string, fn func(entity.MongoMessage) error,
+	) error
+
+	CreateOutboxEntries(ctx context.Context, entries []entity.OutboxEntry) error
+	ClaimOutboxEntry(
+		ctx context.Context, now time.Time, lease time.Duration,
+	) (entity.OutboxEntry, error)
+	UpdateOutboxEntry(ctx context.Context, entry entity.OutboxEntry) error
+	PendingOutboxFiles(ctx context.Context) ([]string, error)
+	ListOutboxEntries(ctx context.Context, digestID string) ([]entity.OutboxEntry, error)
+}
+
 type Service struct {
 	logger    *slog.Logger
 	cfg       config.Config
-	email     gmail.Gmail
-	repo      repository.Repository
-	kafka     broker.Consumer
-	wordCount int
+	mail      sink.Mail
+	repo      Store
+	kafka     MessageSource
+	mapper    *mapping.Mapper
+	scheduler srs.Scheduler
+	grade     srs.Grade
+	signer    *review.Signer
+	stats     *stats.Collector
+	server    *server.Server
+	workspace *workspace.Workspace
+	sinks     map[string]sink.Sink
+}
+
+// digestResult describes the words written by writeWordsToFile. Queued reports whether the
+// deliveries of the digest are in the outbox, which then owns the export file.
+type digestResult struct {
+	ID     string
+	Count  int
+	Queued bool
 }
 
 // New creates a new Service instance with the provided dependencies.
@@ -34,21 +112,116 @@ func New(
 	logger *slog.Logger, cfg config.Config,
 	repo repository.Repository,
 ) (Service, error) {
-	logger.Info("email initializing")
-	email := gmail.New(cfg.Gmail)
+	s, err := NewAdmin(logger, cfg, repo)
+	if err != nil {
+		return Service{}, err
+	}
 
 	logger.Info("kafka initializing")
-	kafka, err := broker.New(logger, cfg.Kafka)
+	consumer, err := broker.New(logger, cfg.Kafka)
+	if err != nil {
+		logger.Error("failed to create kafka consumer", slog.String("error", err.Error()))
+		return Service{}, fmt.Errorf("service.New: %w", err)
+	}
+	s.kafka = &consumer
+
+	return s, nil
+}
+
+// NewAdmin creates a Service without a Kafka consumer for administrative commands such as
+// listing and resending digests. Run must not be called on it.
+func NewAdmin(
+	logger *slog.Logger, cfg config.Config,
+	repo repository.Repository,
+) (Service, error) {
+	logger.Info("email initializing")
+	email, err := gmail.New(cfg.Gmail, cfg.Mail)
+	if err != nil {
+		logger.Error("failed to create mail sender", slog.String("error", err.Error()))
+		return Service{}, fmt.Errorf("service.New: %w", err)
+	}
+
+	mapper, err := mapping.New(cfg.Kafka.TopicRules())
+	if err != nil {
+		logger.Error("failed to create message mapper", slog.String("error", err.Error()))
+		return Service{}, fmt.Errorf("service.New: %w", err)
+	}
+
+	scheduler, err := srs.New(cfg.SRS.Algorithm, cfg.SRS.Retention)
+	if err != nil {
+		return Service{}, fmt.Errorf("service.New: %w", err)
+	}
+	grade, err := srs.ParseGrade(cfg.SRS.DefaultGrade)
+	if err != nil {
+		return Service{}, fmt.Errorf("service.New: default grade: %w", err)
+	}
+
+	var signer *review.Signer
+	if cfg.Review.Secret != "" {
+		sg := review.NewSigner(cfg.Review.Secret, cfg.Review.TTL, cfg.Server.PublicURL)
+		signer = &sg
+	} else {
+		logger.Info("review links disabled, REVIEW_SECRET is not set")
+	}
+
+	formats := []string{cfg.Export.Format}
+	if cfg.Export.Archive != "" {
+		if _, err := export.ArchiveExtension(cfg.Export.Archive); err != nil {
+			return Service{}, fmt.Errorf("service.New: %w", err)
+		}
+		formats = cfg.Export.FormatList()
+	}
+	for _, format := range formats {
+		// The exporter is only built to validate the configuration; closing it releases the
+		// workbook of the xlsx format.
+		exporter, err := export.New(format, cfg.Export, io.Discard)
+		if err == nil {
+			err = exporter.Close()
+		}
+		if err != nil {
+			return Service{}, fmt.Errorf("service.New: %w", err)
+		}
+	}
+
+	collector, err := stats.New(&repo, cfg.Stats.Timezone, cfg.Stats.Days)
 	if err != nil {
-		logger.Error("failed to create kafka kafka", slog.String("error", err.Error()))
+		return Service{}, fmt.Errorf("service.New: %w", err)
+	}
+
+	ws, err := workspace.New(cfg.Export.Workspace)
+	if err != nil {
+		return Service{}, fmt.Errorf("service.New: %w", err)
+	}
+
+	limiter, err := ratelimit.New(cfg.Mail.Limit, &repo)
+	if err != nil {
+		return Service{}, fmt.Errorf("service.New: %w", err)
+	}
+
+	keys, err := pgpKeys(cfg.SubscriberList())
+	if err != nil {
+		return Service{}, fmt.Errorf("service.New: %w", err)
+	}
+
+	mail := sink.Mail{From: cfg.Gmail.Email, Mailer: &email, Limiter: limiter, Keys: keys}
+	sinks, err := newSinks(cfg, mail)
+	if err != nil {
+		return Service{}, fmt.Errorf("service.New: %w", err)
 	}
 
 	return Service{
-		logger: logger,
-		cfg:    cfg,
-		email:  email,
-		kafka:  kafka,
-		repo:   repo,
+		logger:    logger,
+		cfg:       cfg,
+		mail:      mail,
+		mapper:    mapper,
+		scheduler: scheduler,
+		grade:     grade,
+		signer:    signer,
+		stats:     collector,
+		server:    server.New(logger, cfg.Server),
+		workspace: ws,
+		sinks:     sinks,
+		repo:      &repo,
 	}, nil
 }
 
@@ -56,12 +229,16 @@ func New(
 // file and sending it via email.
 func (s *Service) Run(ctx context.Context) error {
 	var wg sync.WaitGroup
-	wg.Add(2)
+	wg.Add(3)
 
 	defer s.kafka.Close()
 
 	go func() {
 		defer wg.Done()
+		if s.cfg.Kafka.Batch.Size > 1 {
+			s.consumeBatches(ctx)
+			return
+		}
 		s.consumeMessages(ctx)
 	}()
 
@@ -77,46 +254,112 @@ func (s *Service) Run(ctx context.Context) error {
 			}
 		}
 	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.runOutbox(ctx)
+	}()
+	if s.cfg.Stats.Weekly {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runWeeklyStats(ctx)
+		}()
+	}
+
+	go func() {
+		defer wg.Done()
+		s.routes()
+		if err := s.server.Run(ctx); err != nil {
+			s.logger.Error("http server stopped", slog.String("error", err.Error()))
+		}
+	}()
 	s.logger.Info("wait goroutine")
 	wg.Wait()
 	s.logger.Info("finish goroutine")
 	return nil
 }
 
-// consumeMessages reads from Kafka, processes messages, and writes to a file
-func (s *Service) consumeMessages(ctx context.Context) {
-	ch, err := s.kafka.Consume(ctx)
-	if err != nil {
-		s.logger.Error("failed to consume messages", "error", err)
-		return
-	}
+// errUndecodable marks a message that no retry can store, so it is committed and skipped.
+var errUndecodable = errors.New("undecodable message")
 
+// consumeMessages fetches messages one at a time, stores each and commits it. A message that
+// cannot be decoded is logged and committed, so it does not block the ones after it; a message
+// the database failed to store is retried with backoff and committed only once it is stored.
+func (s *Service) consumeMessages(ctx context.Context) {
+	s.logger.Info("start consume messages")
+	wait := retryMin
 	for {
-		select {
-		case <-ctx.Done():
+		msg, err := s.kafka.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				s.logger.Info("stop consume messages")
+				return
+			}
+			// The broker is unreachable; back off instead of retrying in a tight loop.
+			s.logger.Error(
+				"failed to fetch message", slog.String("error", err.Error()),
+				slog.Duration("retry_in", wait),
+			)
+			if !sleep(ctx, wait) {
+				s.logger.Info("stop consume messages")
+				return
+			}
+			wait = min(wait*2, retryMax)
+			continue
+		}
+		wait = retryMin
+		s.logger.Debug(
+			"get message", slog.String("topic", msg.Topic),
+			slog.String("message", string(msg.Value)),
+		)
+		if !s.storeMessage(ctx, msg) {
 			s.logger.Info("stop consume messages")
 			return
-		case msg := <-ch:
-			s.logger.Debug("get message", slog.String("message", string(msg)))
-			if err := s.processMessage(ctx, msg); err != nil {
-				s.logger.Error("failed to process message", "error", err)
-			}
 		}
+		if err := s.kafka.Commit(ctx, msg); err != nil {
+			s.logger.Error("failed to commit message", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// storeMessage processes the message, retrying with backoff while the database fails.
+// It returns false when the context is cancelled before the message is stored.
+func (s *Service) storeMessage(ctx context.Context, msg broker.Message) bool {
+	wait := retryMin
+	for {
+		err := s.processMessage(ctx, msg)
+		if err == nil {
+			return true
+		}
+		if errors.Is(err, errUndecodable) {
+			s.logger.Error("failed to process message", "error", err)
+			return true
+		}
+
+		s.logger.Error(
+			"failed to store message, retrying", slog.String("error", err.Error()),
+			slog.Duration("retry_in", wait),
+		)
+		if !sleep(ctx, wait) {
+			return false
+		}
+		wait = min(wait*2, retryMax)
 	}
 }
 
 // processMessage processes a single message from Kafka,
 // saving it to the database if it's a new event.
-func (s *Service) processMessage(ctx context.Context, msg []byte) error {
+func (s *Service) processMessage(ctx context.Context, msg broker.Message) error {
 	const op = "service.processMessage"
 
-	m, err := toKafkaMessage(msg)
+	m, err := s.mapper.Decode(msg.Topic, msg.Value)
 	if err != nil {
 		s.logger.Error(
 			"failed to decode message", slog.String("error", err.Error()),
-			slog.String("message", string(msg)),
+			slog.String("topic", msg.Topic), slog.String("message", string(msg.Value)),
 		)
-		return fmt.Errorf("%s: %w", op, err)
+		return fmt.Errorf("%s: %w: %w", op, errUndecodable, err)
 	}
 	s.logger.Debug("decode message", slog.Any("message", m))
 
@@ -146,104 +389,358 @@ func (s *Service) processMessage(ctx context.Context, msg []byte) error {
 	return nil
 }
 
-// writeWordsToFileAndSend writes the words from the database to a file and sends the file via
-// email.
+// writeWordsToFileAndSend writes the words from the database to a file, queues its delivery
+// to the sinks of every subscriber and delivers what is due from the outbox. The export
+// directory is kept until the outbox is done with the digest.
 func (s *Service) writeWordsToFileAndSend(ctx context.Context) {
-	file, err := initFile()
+	now := time.Now()
+	s.pruneExports(ctx, now)
+
+	exp, err := s.workspace.Create(now)
+	if err != nil {
+		s.logger.Error("failed to create export directory", "error", err)
+		return
+	}
+	release := func() {
+		if err := s.workspace.Release(exp); err != nil {
+			s.logger.Error("failed to remove export directory", "error", err)
+		}
+	}
+
+	fileName := exportPath(exp, s.cfg.Export)
+	file, err := initFile(fileName)
 	if err != nil {
 		s.logger.Error("failed to initialize file", "error", err)
+		release()
 		return
 	}
-	defer file.Close()
-	s.logger.Debug("file initialize")
+	s.logger.Debug("file initialize", slog.String("path", fileName))
 
-	if err := s.writeWordsToFile(ctx, file); err != nil {
+	result, err := s.writeWordsToFile(ctx, file, exp.Dir)
+	file.Close()
+	if err != nil {
 		s.logger.Error("failed to write words to file", "error", err)
+		if !result.Queued {
+			release()
+		}
 		return
 	}
 	s.logger.Info("words write to file")
 
-	if s.wordCount == 0 {
+	if result.Count == 0 {
 		s.logger.Info("no words to send")
+		release()
 		return
 	}
 
-	msg := gmail.Message{
-		From:    s.cfg.Gmail.Email,
-		To:      []string{s.cfg.Gmail.Email},
-		Subject: "Dictionary",
-		Body:    "List words",
-		File:    wordsFileName,
-	}
-	if err := s.email.SendMessage(msg); err != nil {
-		s.logger.Error("failed to send message", "error", err)
+	s.processOutbox(ctx)
+}
+
+// pruneExports removes the old export directories, except the ones pending outbox entries still
+// deliver from: the outbox releases those once it is done with their digest. Nothing is pruned
+// when the pending entries cannot be listed.
+func (s *Service) pruneExports(ctx context.Context, now time.Time) {
+	files, err := s.repo.PendingOutboxFiles(ctx)
+	if err != nil {
+		s.logger.Error("failed to list pending deliveries", slog.String("error", err.Error()))
 		return
 	}
-	s.logger.Info("message send")
+	inUse := make(map[string]bool, len(files))
+	for _, file := range files {
+		inUse[filepath.Dir(file)] = true
+	}
+
+	if pruned, err := s.workspace.Prune(now, inUse); err != nil {
+		s.logger.Error("failed to prune old exports", "error", err)
+	} else if pruned > 0 {
+		s.logger.Info("old exports removed", slog.Int("count", pruned))
+	}
 }
 
-// writeWordsToFile retrieves words from the database and writes them to the specified file.
-func (s *Service) writeWordsToFile(ctx context.Context, file *os.File) error {
+// writeWordsToFile claims the words due today for a new digest, streams them from the database
+// into the file and the email of every subscriber and queues the deliveries in the outbox. The
+// words stay due until the outbox is done with the digest: it schedules their next review with
+// the default grade and marks them as sent when a delivery succeeded, and leaves them to the
+// next digest when every delivery failed.
+func (s *Service) writeWordsToFile(
+	ctx context.Context, file *os.File, dir string,
+) (digestResult, error) {
 	const op = "service.writeWordsToFile"
 
-	words, err := s.repo.GetWords(ctx)
+	digestID := uuid.NewString()
+	now := time.Now()
+	claimed, err := s.repo.ClaimWords(ctx, digestID, srs.DueBy(now))
 	if err != nil {
-		s.logger.Error("failed to get words from database", slog.String("error", err.Error()))
-		return fmt.Errorf("%s: %w", op, err)
+		s.logger.Error("failed to claim words", slog.String("error", err.Error()))
+		return digestResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+	s.logger.Debug("claim words", slog.String("digest_id", digestID), slog.Int64("count", claimed))
+	if claimed == 0 {
+		return digestResult{ID: digestID}, nil
 	}
-	s.logger.Debug("get words count", slog.Int("count", len(words)))
 
-	s.wordCount = 0
+	exporter, err := s.newExporter(s.cfg.Export, digestID, dir, file)
+	if err != nil {
+		return digestResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+	subscribers := s.cfg.SubscriberList()
 
-	for _, word := range words {
-		line := fmt.Sprintf("%s;%s\n", word.Word, word.Translation)
-		if _, err := file.WriteString(line); err != nil {
-			s.logger.Error(
-				"failed to write to file", slog.String("error", err.Error()),
-				slog.String("line", line),
-			)
-			return fmt.Errorf("%s: %w", op, err)
+	// The event IDs are recorded for the digest history while the words are streamed.
+	var (
+		ids []uuid.UUID
+		seq int
+	)
+	flush := func() error {
+		if len(ids) == 0 {
+			return nil
 		}
-		if err := s.repo.UpdateWord(ctx, word.EventID); err != nil {
+		err := s.repo.AddDigestWords(ctx, digestID, seq, ids)
+		ids, seq = nil, seq+1
+		return err
+	}
+	count, contents, err := s.renderDigest(
+		digestID, exporter, subscribers, now,
+		func(fn func(entity.MongoMessage) error) error {
+			return s.repo.StreamDigest(ctx, digestID, func(word entity.MongoMessage) error {
+				ids = append(ids, word.EventID)
+				if len(ids) == repository.DigestWordsChunk {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+				return fn(word)
+			})
+		},
+	)
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return digestResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = s.repo.CreateDigest(ctx, entity.Digest{
+		ID:         digestID,
+		CreatedAt:  now,
+		Count:      count,
+		Recipients: recipients(subscribers),
+		Format:     s.cfg.Export.Format,
+		Formats:    s.cfg.Export.Formats,
+		Archive:    s.cfg.Export.Archive,
+	})
+	if err != nil {
+		s.logger.Error(
+			"failed to record digest", slog.String("error", err.Error()),
+			slog.String("digest_id", digestID),
+		)
+		return digestResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = s.enqueue(ctx, digestID, file.Name(), count, subscribers, contents, false, now)
+	if err != nil {
+		return digestResult{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.logger.Info("words written to file", slog.String("digest_id", digestID), "count", count)
+
+	return digestResult{ID: digestID, Count: count, Queued: true}, nil
+}
+
+// renderDigest writes the words passed by stream to the exporter and the email of every
+// subscriber and closes the exporter. It returns the number of words and the rendered email of
+// each subscriber.
+func (s *Service) renderDigest(
+	digestID string,
+	exporter export.Exporter,
+	subscribers []config.Subscriber,
+	now time.Time,
+	stream func(fn func(entity.MongoMessage) error) error,
+) (int, []digest.Content, error) {
+	builders := make([]digest.Builder, 0, len(subscribers))
+	for _, sub := range subscribers {
+		builders = append(builders, digest.NewBuilder(sub, s.cfg.Digest.BodyLimit))
+	}
+	count, capped := 0, 0
+	err := stream(func(word entity.MongoMessage) error {
+		err := exporter.Write(word)
+		if errors.Is(err, export.ErrCardLimit) {
+			// Logged once below rather than for each of possibly many words.
+			capped++
+		} else if errors.Is(err, export.ErrSkipped) {
+			// The word stays in the email and the other formats.
+			s.logger.Warn(
+				"word left out of the file", slog.String("error", err.Error()),
+				slog.String("digest_id", digestID), slog.String("event_id", word.EventID.String()),
+			)
+		} else if err != nil {
 			s.logger.Error(
-				"failed to update word", slog.String("error", err.Error()),
+				"failed to write to file", slog.String("error", err.Error()),
 				slog.Any("word", word),
 			)
-			return fmt.Errorf("%s: %w", op, err)
+			return err
 		}
-		s.wordCount++
+		item := s.bodyItem(word, digestID, now)
+		for _, b := range builders {
+			b.Add(item)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		// Closing releases the temporary files of a bundle; the partial file is not sent.
+		_ = exporter.Close()
+		return 0, nil, err
+	}
+	if err := exporter.Close(); err != nil {
+		s.logger.Error("failed to flush file", slog.String("error", err.Error()))
+		return 0, nil, err
+	}
+	if capped > 0 {
+		s.logger.Warn(
+			"words past the card limit left out of the file", slog.Int("count", capped),
+			slog.String("digest_id", digestID),
+		)
 	}
 
-	s.logger.Info("words written to file", "count", len(words))
+	contents := make([]digest.Content, 0, len(builders))
+	for _, b := range builders {
+		content, err := b.Render()
+		if err != nil {
+			return 0, nil, err
+		}
+		contents = append(contents, content)
+	}
+	return count, contents, nil
+}
 
-	return nil
+func recipients(subscribers []config.Subscriber) []string {
+	emails := make([]string, 0, len(subscribers))
+	for _, sub := range subscribers {
+		emails = append(emails, sub.Email)
+	}
+	return emails
 }
 
-// toKafkaMessage decodes JSON bytes into KafkaMessage struct
-func toKafkaMessage(msg []byte) (entity.KafkaMessage, error) {
-	const op = "service.toKafkaMessage"
-	var m entity.KafkaMessage
-	err := json.Unmarshal(msg, &m)
-	if err != nil {
-		return entity.KafkaMessage{}, fmt.Errorf("%s: %w", op, err)
+// newSinks builds the configured sinks and the built-in "email" sink, unless one of the same
+// name is configured, and checks that every sink named by a subscriber exists.
+func newSinks(cfg config.Config, mail sink.Mail) (map[string]sink.Sink, error) {
+	sinks := map[string]sink.Sink{
+		sink.TypeEmail: sink.NewEmail(sink.TypeEmail, mail),
+	}
+	for _, sc := range cfg.Sinks {
+		sk, err := sink.New(sc, mail)
+		if err != nil {
+			return nil, err
+		}
+		sinks[sc.Name] = sk
+	}
+
+	for _, sub := range cfg.SubscriberList() {
+		for _, name := range sub.SinkList() {
+			if _, ok := sinks[name]; !ok {
+				return nil, fmt.Errorf("subscriber %s: %w: %q", sub.Email, sink.ErrUnknownSink, name)
+			}
+		}
+	}
+	return sinks, nil
+}
+
+// pgpKeys loads the OpenPGP keys of the subscribers that have one, by address.
+func pgpKeys(subscribers []config.Subscriber) (map[string]openpgp.EntityList, error) {
+	keys := make(map[string]openpgp.EntityList)
+	for _, sub := range subscribers {
+		if sub.PGPKey == "" {
+			continue
+		}
+		key, err := pgp.LoadKey(sub.PGPKey)
+		if err != nil {
+			return nil, fmt.Errorf("subscriber %s: %w", sub.Email, err)
+		}
+		keys[sub.Email] = key
+	}
+	return keys, nil
+}
+
+// exportPath returns the dated path of the digest attachment in the export directory: the
+// archive when one is configured, the file of the export format otherwise.
+func exportPath(exp workspace.Export, cfg config.Export) string {
+	if cfg.Archive != "" {
+		return exp.Path(wordsFileName, cfg.Archive)
+	}
+	return exp.Path(wordsFileName, export.Extension(cfg.Format))
+}
+
+// newExporter returns the exporter of the format, or a bundle of all formats when an archive
+// is configured. The bundle keeps its intermediate files in dir.
+func (s *Service) newExporter(
+	cfg config.Export, digestID, dir string, w io.Writer,
+) (export.Exporter, error) {
+	if cfg.Archive != "" {
+		return export.NewBundle(cfg.Archive, cfg.FormatList(), cfg, digestID, dir, w)
+	}
+	return export.New(cfg.Format, cfg, w)
+}
+
+// bodyItem renders a word for the email body, with review links when they are enabled.
+func (s *Service) bodyItem(word entity.MongoMessage, digestID string, now time.Time) digest.Item {
+	item := digest.Item{Word: word.Word, Translation: word.Translation}
+	if s.signer == nil {
+		return item
+	}
+
+	links := s.signer.Links(word.EventID, digestID, now)
+	for _, g := range review.Grades() {
+		item.Links = append(item.Links, digest.Link{Label: g.String(), URL: links[g]})
+	}
+	return item
+}
+
+// routes registers the HTTP handlers of the service. Everything but the signed review links
+// exposes the stored words or the digests, so it is only served with the admin token.
+func (s *Service) routes() {
+	if token := s.cfg.Server.AdminToken; token != "" {
+		s.server.Handle(
+			"GET /stats", server.RequireToken(token, server.StatsHandler(s.logger, s.stats)),
+		)
+		s.server.Handle("GET /book", server.RequireToken(token, server.BookHandler(s.logger, s)))
+		s.server.Handle("GET /debug/vars", server.RequireToken(token, expvar.Handler()))
+		s.server.Handle("GET /digests", server.RequireToken(token, server.DigestsHandler(s.logger, s)))
+		s.server.Handle(
+			"GET /digests/{id}", server.RequireToken(token, server.DigestHandler(s.logger, s)),
+		)
+		s.server.Handle(
+			"POST /digests/{id}/resend",
+			server.RequireToken(token, server.ResendHandler(s.logger, s)),
+		)
+	}
+	if s.signer != nil {
+		s.server.Handle("GET /review", server.ReviewPageHandler(s.logger, *s.signer))
+		s.server.Handle("POST /review", server.ReviewHandler(s.logger, *s.signer, s))
 	}
-	return m, nil
 }
 
 // toMongoMessage converts KafkaMessage to MongoMessage format
 func toMongoMessage(msg entity.KafkaMessage) entity.MongoMessage {
+	now := time.Now().UTC()
 	return entity.MongoMessage{
 		EventID:     msg.EventID,
 		Word:        msg.Word,
 		Translation: msg.Translation,
+		Source:      msg.Source,
+		LangFrom:    msg.LangFrom,
+		LangTo:      msg.LangTo,
+		Example:     msg.Example,
+		Tags:        msg.Tags,
+		CreatedAt:   now,
 		Sent:        false,
+		SRS:         entity.SRS{Due: now},
 	}
 }
 
-// initFile creates a new file with the name wordsFileName.
-func initFile() (*os.File, error) {
+// initFile creates a new file with the given name, failing if it already exists.
+func initFile(name string) (*os.File, error) {
 	const op = "service.initFile"
-	file, err := os.OpenFile(wordsFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
 	if err != nil {
 		return nil, fmt.Errorf("%s: open file: %w", op, err)
 	}
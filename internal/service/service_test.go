@@ -0,0 +1,71 @@
+package service
+
+import (
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/server"
+	"github.com/fentezi/export-word/internal/stats"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoutesRequireToken(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	collector, err := stats.New(statsStore{}, "UTC", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{"/stats", "/book", "/debug/vars", "/digests"}
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		status int
+	}{
+		{name: "not served without a token", status: http.StatusNotFound},
+		{name: "missing token", token: "admin", status: http.StatusUnauthorized},
+		{
+			name: "wrong token", token: "admin", header: "Bearer guess",
+			status: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{}
+			cfg.Server.AdminToken = tt.token
+			s := &Service{
+				logger: logger, cfg: cfg, stats: collector, server: server.New(logger, cfg.Server),
+			}
+			s.routes()
+
+			for _, path := range paths {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				if tt.header != "" {
+					req.Header.Set("Authorization", tt.header)
+				}
+				rec := httptest.NewRecorder()
+				s.server.ServeHTTP(rec, req)
+				if rec.Code != tt.status {
+					t.Errorf("GET %s = %d, want %d", path, rec.Code, tt.status)
+				}
+			}
+		})
+	}
+
+	cfg := config.Config{}
+	cfg.Server.AdminToken = "admin"
+	s := &Service{logger: logger, cfg: cfg, stats: collector, server: server.New(logger, cfg.Server)}
+	s.routes()
+	for _, path := range []string{"/stats", "/debug/vars"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer admin")
+		rec := httptest.NewRecorder()
+		s.server.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s with the token = %d, want 200", path, rec.Code)
+		}
+	}
+}
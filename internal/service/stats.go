@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/digest"
+	"github.com/fentezi/export-word/internal/sink"
+	"github.com/fentezi/export-word/internal/stats"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const statsSubject = "Weekly progress"
+
+// runWeeklyStats sends the weekly summary email at the configured weekday and hour until the
+// context is cancelled.
+func (s *Service) runWeeklyStats(ctx context.Context) {
+	weekday, err := parseWeekday(s.cfg.Stats.Weekday)
+	if err != nil {
+		s.logger.Error("weekly statistics disabled", slog.String("error", err.Error()))
+		return
+	}
+	loc, err := time.LoadLocation(s.cfg.Stats.Timezone)
+	if err != nil {
+		s.logger.Error("weekly statistics disabled", slog.String("error", err.Error()))
+		return
+	}
+
+	for {
+		next := nextWeekly(time.Now().In(loc), weekday, s.cfg.Stats.Hour)
+		s.logger.Info("next weekly statistics", slog.Time("at", next))
+		select {
+		case <-ctx.Done():
+			s.logger.Info("stop weekly statistics goroutine")
+			return
+		case <-time.After(time.Until(next)):
+			if err := s.sendWeeklyStats(ctx); err != nil {
+				s.logger.Error("failed to send weekly statistics", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// sendWeeklyStats mails the summary with its chart to every subscriber in a message of its own,
+// encrypted to the subscriber's key like the digest. A subscriber that cannot be mailed does
+// not keep the summary from the others.
+func (s *Service) sendWeeklyStats(ctx context.Context) error {
+	const op = "service.sendWeeklyStats"
+
+	report, err := s.stats.Report(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	body, chart, err := stats.Summary(report)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	email := sink.NewEmail(sink.TypeEmail, s.mail)
+	content := digest.Content{
+		HTML:   body,
+		Embeds: []digest.Attachment{{Name: stats.ChartName, Data: chart}},
+	}
+	var errs []error
+	for _, sub := range s.cfg.SubscriberList() {
+		d := sink.Delivery{Recipient: sub.Email, Subject: statsSubject, Content: content}
+		if err := s.deliverAdmitted(ctx, email, d); err != nil {
+			s.logger.Error(
+				"failed to send weekly statistics", slog.String("error", err.Error()),
+				slog.String("to", sub.Email),
+			)
+			errs = append(errs, fmt.Errorf("%s: %w", sub.Email, err))
+			continue
+		}
+		s.logger.Debug("weekly statistics sent", slog.String("to", sub.Email))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.logger.Info("weekly statistics sent", slog.Int64("total", report.Total))
+	return nil
+}
+
+// deliverAdmitted delivers d to the sink, waiting out the windows the sink defers it to.
+func (s *Service) deliverAdmitted(ctx context.Context, sk sink.Sink, d sink.Delivery) error {
+	for {
+		err := sk.Deliver(ctx, d)
+		var deferred *sink.DeferredError
+		if !errors.As(err, &deferred) {
+			return err
+		}
+		s.logger.Info(
+			"mail deferred", slog.String("reason", deferred.Reason),
+			slog.Time("until", deferred.Until),
+		)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Until(deferred.Until)):
+		}
+	}
+}
+
+// nextWeekly returns the next time after now that falls on weekday at hour:00.
+func nextWeekly(now time.Time, weekday time.Weekday, hour int) time.Time {
+	y, m, d := now.Date()
+	next := time.Date(y, m, d, hour, 0, 0, 0, now.Location())
+	next = next.AddDate(0, 0, (int(weekday)-int(now.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), name) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", name)
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/smtptest"
+	"github.com/fentezi/export-word/internal/stats"
+	"testing"
+	"time"
+)
+
+// statsStore reports a fixed number of words.
+type statsStore struct{}
+
+func (statsStore) CountWordsByDate(
+	ctx context.Context, format string, since time.Time, timezone string,
+) ([]entity.Count, error) {
+	return []entity.Count{{Key: time.Now().Format("2006-01-02"), Count: 3}}, nil
+}
+
+func (statsStore) CountWordsByFields(
+	ctx context.Context, sep string, fields ...string,
+) ([]entity.Count, error) {
+	return nil, nil
+}
+
+func (statsStore) CountWords(
+	ctx context.Context, dueBy time.Time,
+) (total, unsent, due int64, err error) {
+	return 3, 1, 1, nil
+}
+
+func TestSendWeeklyStats(t *testing.T) {
+	srv := smtptest.NewServer(t)
+	cfg := config.Config{Subscribers: []config.Subscriber{
+		{Email: "list@example.com"},
+		{Email: "quiz@example.com", Mode: "quiz"},
+	}}
+	s := newTestService(t, srv, cfg)
+	collector, err := stats.New(statsStore{}, "UTC", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.stats = collector
+
+	if err := s.sendWeeklyStats(context.Background()); err != nil {
+		t.Fatalf("sendWeeklyStats: %v", err)
+	}
+
+	got := make(map[string]bool)
+	for _, msg := range srv.WaitMessages(t, 2) {
+		if len(msg.To) != 1 {
+			t.Fatalf("message to %v, want one recipient per message", msg.To)
+		}
+		got[msg.To[0]] = true
+		msg.AssertRecipients(t, msg.To[0])
+		msg.AssertSubject(t, statsSubject)
+		msg.AssertBodyContains(t, "text/html", "cid:"+stats.ChartName)
+		if _, ok := msg.File(stats.ChartName); !ok {
+			t.Errorf("message to %s lacks the chart, files: %v", msg.To[0], msg.Files())
+		}
+	}
+	for _, sub := range cfg.Subscribers {
+		if !got[sub.Email] {
+			t.Errorf("no summary sent to %s", sub.Email)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/repository"
+	"github.com/google/uuid"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memStore is an in-memory Store. Words keep their insertion order like the _id order of the
+// collection, and every method fails once the context is done, as the database does. Its
+// WordStore methods are with the consumer tests.
+type memStore struct {
+	mu          sync.Mutex
+	words       []entity.MongoMessage
+	index       map[uuid.UUID]int
+	digests     map[string]entity.Digest
+	digestWords map[string][][]uuid.UUID
+	outbox      []entity.OutboxEntry
+}
+
+var _ Store = (*memStore)(nil)
+
+func newMemStore(words ...entity.MongoMessage) *memStore {
+	m := &memStore{
+		index:       make(map[uuid.UUID]int),
+		digests:     make(map[string]entity.Digest),
+		digestWords: make(map[string][][]uuid.UUID),
+	}
+	for _, w := range words {
+		m.insert(w)
+	}
+	return m
+}
+
+func (m *memStore) insert(w entity.MongoMessage) bool {
+	if _, ok := m.index[w.EventID]; ok {
+		return false
+	}
+	m.index[w.EventID] = len(m.words)
+	m.words = append(m.words, w)
+	return true
+}
+
+// word returns the i-th word. The lock is not held while the callers of the Stream methods
+// run, so that they can write to the store like they do to the database.
+func (m *memStore) word(i int) (entity.MongoMessage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i >= len(m.words) {
+		return entity.MongoMessage{}, false
+	}
+	return m.words[i], true
+}
+
+func (m *memStore) ClaimWords(
+	ctx context.Context,
+	digestID string,
+	dueBy time.Time,
+) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pending := make(map[string]bool)
+	for _, e := range m.outbox {
+		if e.Status == entity.OutboxPending && !e.Resend {
+			pending[e.DigestID] = true
+		}
+	}
+	var claimed int64
+	for i := range m.words {
+		if m.words[i].SRS.Due.Before(dueBy) && !pending[m.words[i].DigestID] {
+			m.words[i].DigestID = digestID
+			claimed++
+		}
+	}
+	return claimed, nil
+}
+
+func (m *memStore) StreamDigest(
+	ctx context.Context,
+	digestID string,
+	fn func(entity.MongoMessage) error,
+) error {
+	return m.StreamWords(ctx, func(w entity.MongoMessage) error {
+		if w.DigestID != digestID {
+			return nil
+		}
+		return fn(w)
+	})
+}
+
+func (m *memStore) StreamWords(ctx context.Context, fn func(entity.MongoMessage) error) error {
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		w, ok := m.word(i)
+		if !ok {
+			return nil
+		}
+		if err := fn(w); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *memStore) MarkDigestSent(ctx context.Context, digestID string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var modified int64
+	for i := range m.words {
+		if m.words[i].DigestID == digestID {
+			m.words[i].Sent = true
+			m.words[i].SentAt = time.Now().UTC()
+			modified++
+		}
+	}
+	return modified, nil
+}
+
+func (m *memStore) RescheduleDigest(
+	ctx context.Context,
+	digestID string,
+	fn func(entity.MongoMessage) entity.SRS,
+) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var modified int64
+	for i := range m.words {
+		if m.words[i].DigestID == digestID && m.words[i].ScheduledBy != digestID {
+			prev := m.words[i].SRS
+			m.words[i].SRS = fn(m.words[i])
+			m.words[i].PrevSRS = &prev
+			m.words[i].ScheduledBy = digestID
+			modified++
+		}
+	}
+	return modified, nil
+}
+
+func (m *memStore) ApplyReview(ctx context.Context, review entity.Review) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.index[review.EventID]
+	if !ok || m.words[i].DigestID != review.DigestID {
+		return fmt.Errorf("memStore: %w", repository.ErrDocumentNotFound)
+	}
+	before := review.Before
+	m.words[i].SRS, m.words[i].PrevSRS = review.After, &before
+	m.words[i].ScheduledBy = review.DigestID
+	m.words[i].Reviews = append(m.words[i].Reviews, review)
+	return nil
+}
+
+func (m *memStore) CreateDigest(ctx context.Context, digest entity.Digest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.digests[digest.ID] = digest
+	return nil
+}
+
+func (m *memStore) AddDigestWords(
+	ctx context.Context,
+	digestID string,
+	seq int,
+	eventIDs []uuid.UUID,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if seq != len(m.digestWords[digestID]) {
+		return fmt.Errorf("memStore: chunk %d of digest %s out of order", seq, digestID)
+	}
+	m.digestWords[digestID] = append(m.digestWords[digestID], eventIDs)
+	return nil
+}
+
+func (m *memStore) ListDigests(ctx context.Context, limit int64) ([]entity.Digest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	digests := make([]entity.Digest, 0, len(m.digests))
+	for _, d := range m.digests {
+		digests = append(digests, d)
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return digests[i].CreatedAt.After(digests[j].CreatedAt)
+	})
+	return digests[:min(int64(len(digests)), limit)], nil
+}
+
+func (m *memStore) GetDigest(ctx context.Context, digestID string) (entity.Digest, error) {
+	if err := ctx.Err(); err != nil {
+		return entity.Digest{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.digests[digestID]
+	if !ok {
+		return entity.Digest{}, fmt.Errorf("memStore: %w", repository.ErrDocumentNotFound)
+	}
+	return d, nil
+}
+
+func (m *memStore) StreamDigestWords(
+	ctx context.Context,
+	digestID string,
+	fn func(entity.MongoMessage) error,
+) error {
+	m.mu.Lock()
+	chunks := m.digestWords[digestID]
+	m.mu.Unlock()
+
+	for _, chunk := range chunks {
+		for _, id := range chunk {
+			w, err := m.GetWordByEventID(ctx, id)
+			if err != nil {
+				continue
+			}
+			if err := fn(w); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+func (m *memStore) CreateOutboxEntries(ctx context.Context, entries []entity.OutboxEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outbox = append(m.outbox, entries...)
+	return nil
+}
+
+func (m *memStore) ClaimOutboxEntry(
+	ctx context.Context,
+	now time.Time,
+	lease time.Duration,
+) (entity.OutboxEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return entity.OutboxEntry{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	due := -1
+	for i, e := range m.outbox {
+		if e.Status != entity.OutboxPending || e.NextAttemptAt.After(now) {
+			continue
+		}
+		if due < 0 || e.NextAttemptAt.Before(m.outbox[due].NextAttemptAt) {
+			due = i
+		}
+	}
+	if due < 0 {
+		return entity.OutboxEntry{}, fmt.Errorf("memStore: %w", repository.ErrDocumentNotFound)
+	}
+	m.outbox[due].NextAttemptAt = now.Add(lease)
+	return m.outbox[due], nil
+}
+
+func (m *memStore) UpdateOutboxEntry(ctx context.Context, entry entity.OutboxEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.outbox {
+		if m.outbox[i].ID != entry.ID {
+			continue
+		}
+		e := &m.outbox[i]
+		e.Status, e.Attempts, e.NextAttemptAt = entry.Status, entry.Attempts, entry.NextAttemptAt
+		e.LastError, e.DeliveredAt = entry.LastError, entry.DeliveredAt
+		return nil
+	}
+	return nil
+}
+
+func (m *memStore) PendingOutboxFiles(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]bool)
+	var files []string
+	for _, e := range m.outbox {
+		if e.Status == entity.OutboxPending && e.File != "" && !seen[e.File] {
+			seen[e.File] = true
+			files = append(files, e.File)
+		}
+	}
+	return files, nil
+}
+
+func (m *memStore) ListOutboxEntries(
+	ctx context.Context,
+	digestID string,
+) ([]entity.OutboxEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := []entity.OutboxEntry{}
+	for _, e := range m.outbox {
+		if e.DigestID == digestID {
+			e.HTML, e.Attachments = "", nil
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+// unsend makes every word due and unsent again, as if it had never been exported.
+func (m *memStore) unsend() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.words {
+		m.words[i].Sent, m.words[i].DigestID, m.words[i].SRS = false, "", entity.SRS{}
+		m.words[i].PrevSRS, m.words[i].ScheduledBy = nil, ""
+	}
+}
+
+// sent returns the number of words marked as sent.
+func (m *memStore) sent() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, w := range m.words {
+		if w.Sent {
+			n++
+		}
+	}
+	return n
+}
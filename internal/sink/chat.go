@@ -0,0 +1,349 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"hash/fnv"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	ChatSlack      = "slack"
+	ChatMattermost = "mattermost"
+
+	defaultChatSample = 10
+	defaultEmoji      = ":star:"
+	// slackSectionLimit and mattermostPostLimit stay below the 3000 characters of a Block Kit
+	// section and the 16383 characters of a Mattermost post.
+	slackSectionLimit   = 2900
+	mattermostPostLimit = 16000
+)
+
+var (
+	slackEscaper    = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	markdownEscaper = strings.NewReplacer(
+		`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "~", `\~`, "[", `\[`, "]", `\]`,
+		"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "\r\n", " ", "\n", " ",
+	)
+)
+
+// chatWord is a word picked for the chat message with the emoji of its highlight rule.
+type chatWord struct {
+	word  entity.MongoMessage
+	emoji string
+}
+
+// Chat posts a sample of the digest to a Slack or Mattermost incoming webhook: Block Kit
+// blocks for Slack and a Markdown post for Mattermost. The channel is shared by the
+// subscribers that list the sink.
+type Chat struct {
+	name      string
+	url       string
+	style     string
+	username  string
+	sample    int
+	featured  bool
+	highlight []config.Highlight
+	client    *http.Client
+}
+
+func NewChat(name string, cfg config.ChatSink) (*Chat, error) {
+	url, err := readSecret(cfg.URL, cfg.URLFile)
+	if err != nil {
+		return nil, err
+	}
+	if url == "" {
+		return nil, errors.New("chat webhook url is empty")
+	}
+	style := cfg.Style
+	switch style {
+	case "":
+		style = ChatSlack
+	case ChatSlack, ChatMattermost:
+	default:
+		return nil, fmt.Errorf("unknown chat style %q", style)
+	}
+	sample := cfg.Sample
+	if sample <= 0 {
+		sample = defaultChatSample
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	return &Chat{
+		name:      name,
+		url:       url,
+		style:     style,
+		username:  cfg.Username,
+		sample:    sample,
+		featured:  cfg.WordOfTheDay,
+		highlight: cfg.Highlight,
+		client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (c *Chat) Name() string {
+	return c.name
+}
+
+func (c *Chat) Shared() bool {
+	return true
+}
+
+// Deliver posts the headline of the digest and, unless it is a quiz, a sample of its words.
+func (c *Chat) Deliver(ctx context.Context, d Delivery) error {
+	const op = "sink.Chat.Deliver"
+
+	var (
+		featured *chatWord
+		words    []chatWord
+	)
+	if !d.Quiz {
+		var err error
+		if featured, words, err = c.pick(d); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	var payloads []any
+	if c.style == ChatMattermost {
+		payloads = c.mattermost(d, featured, words)
+	} else {
+		payloads = []any{c.slack(d, featured, words)}
+	}
+
+	for _, p := range payloads {
+		if err := c.post(ctx, p); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	return nil
+}
+
+// sampledWord is a word kept by reservoir sampling with its position in the digest.
+type sampledWord struct {
+	pos  int
+	word chatWord
+}
+
+// pick goes through every word of the digest and returns the word of the day, when enabled,
+// and up to sample other words: the highlighted ones first, then randomly chosen ones in
+// digest order. The random choices are seeded with the sink and the digest, so that every
+// attempt and resend of the digest posts the same word of the day and sample.
+func (c *Chat) pick(d Delivery) (*chatWord, []chatWord, error) {
+	r := rand.New(rand.NewPCG(chatSeed(c.name, d.DigestID)))
+	keep := c.sample + btoi(c.featured)
+	var (
+		highlighted []chatWord
+		rest        []sampledWord
+		seen        int
+	)
+	add := func(w entity.MongoMessage) error {
+		if emoji, ok := c.match(w); ok {
+			if len(highlighted) < keep {
+				highlighted = append(highlighted, chatWord{word: w, emoji: emoji})
+			}
+			return nil
+		}
+		// Reservoir sampling keeps every other word equally likely to be picked.
+		seen++
+		sw := sampledWord{pos: seen, word: chatWord{word: w}}
+		if len(rest) < keep {
+			rest = append(rest, sw)
+		} else if i := r.IntN(seen); i < keep {
+			rest[i] = sw
+		}
+		return nil
+	}
+	if d.Stream != nil {
+		if err := d.Stream(add); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		for _, w := range d.Words {
+			_ = add(w)
+		}
+	}
+	slices.SortFunc(rest, func(a, b sampledWord) int { return a.pos - b.pos })
+
+	var featured *chatWord
+	if c.featured {
+		switch {
+		case len(highlighted) > 0:
+			featured, highlighted = &highlighted[0], highlighted[1:]
+		case len(rest) > 0:
+			i := r.IntN(len(rest))
+			featured = &rest[i].word
+			rest = slices.Delete(slices.Clone(rest), i, i+1)
+		}
+	}
+
+	words := highlighted[:min(len(highlighted), c.sample)]
+	if n := c.sample - len(words); n > 0 && len(rest) > 0 {
+		picked := r.Perm(len(rest))[:min(n, len(rest))]
+		slices.Sort(picked)
+		for _, i := range picked {
+			words = append(words, rest[i].word)
+		}
+	}
+	return featured, words, nil
+}
+
+// chatSeed derives the seed of the random choices for a digest posted by the sink.
+func chatSeed(sink, digestID string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(sink))
+	seed := h.Sum64()
+	h.Write([]byte{0})
+	h.Write([]byte(digestID))
+	return seed, h.Sum64()
+}
+
+// match returns the emoji of the first highlight rule the word matches.
+func (c *Chat) match(w entity.MongoMessage) (string, bool) {
+	for _, h := range c.highlight {
+		if h.Tag != "" && !slices.Contains(w.Tags, h.Tag) {
+			continue
+		}
+		if h.Source != "" && h.Source != w.Source {
+			continue
+		}
+		if h.Lang != "" && h.Lang != w.LangFrom+"-"+w.LangTo {
+			continue
+		}
+		if h.Emoji == "" {
+			return defaultEmoji, true
+		}
+		return h.Emoji, true
+	}
+	return "", false
+}
+
+func (c *Chat) slack(d Delivery, featured *chatWord, words []chatWord) map[string]any {
+	title := fmt.Sprintf("%s: %d words", d.Subject, d.Count)
+	blocks := []any{
+		map[string]any{"type": "header", "text": plainText(title)},
+	}
+	if featured != nil {
+		text := "*Word of the day:* " + chatLine(featured.word, "", slackEscaper, "*", "_")
+		blocks = append(blocks, section(text))
+	}
+	if len(words) > 0 {
+		blocks = append(blocks, map[string]any{"type": "divider"})
+		lines := make([]string, 0, len(words))
+		for _, w := range words {
+			lines = append(lines, chatLine(w.word, w.emoji, slackEscaper, "*", "_"))
+		}
+		for _, text := range chunkLines(lines, slackSectionLimit) {
+			blocks = append(blocks, section(text))
+		}
+	}
+	if more := d.Count - len(words) - btoi(featured != nil); more > 0 && !d.Quiz {
+		blocks = append(blocks, map[string]any{
+			"type":     "context",
+			"elements": []any{mrkdwn(fmt.Sprintf("… and %d more in the export.", more))},
+		})
+	}
+
+	payload := map[string]any{"text": title, "blocks": blocks}
+	if c.username != "" {
+		payload["username"] = c.username
+	}
+	return payload
+}
+
+func (c *Chat) mattermost(d Delivery, featured *chatWord, words []chatWord) []any {
+	lines := []string{fmt.Sprintf("#### %s: %d words", markdownEscaper.Replace(d.Subject), d.Count)}
+	if featured != nil {
+		lines = append(
+			lines,
+			"**Word of the day:** "+chatLine(featured.word, "", markdownEscaper, "**", "*"),
+			"",
+		)
+	}
+	for _, w := range words {
+		// Indent the example so that it stays in the list item.
+		line := chatLine(w.word, w.emoji, markdownEscaper, "**", "*")
+		lines = append(lines, "- "+strings.ReplaceAll(line, "\n", "  \n  "))
+	}
+	if more := d.Count - len(words) - btoi(featured != nil); more > 0 && !d.Quiz {
+		lines = append(lines, "", fmt.Sprintf("*… and %d more in the export.*", more))
+	}
+
+	var payloads []any
+	for _, text := range chunkLines(lines, mattermostPostLimit) {
+		payload := map[string]any{"text": text}
+		if c.username != "" {
+			payload["username"] = c.username
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+func (c *Chat) post(ctx context.Context, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// chatLine renders a word as "emoji bold(word) — translation" with the example in italics on
+// the next line.
+func chatLine(w entity.MongoMessage, emoji string, esc *strings.Replacer, bold, italic string) string {
+	field := func(s string) string {
+		return esc.Replace(truncate(s, fieldLimit))
+	}
+	line := bold + field(w.Word) + bold + " — " + field(w.Translation)
+	if emoji != "" {
+		line = emoji + " " + line
+	}
+	if w.Example != "" {
+		line += "\n" + italic + field(w.Example) + italic
+	}
+	return line
+}
+
+func plainText(text string) map[string]any {
+	return map[string]any{"type": "plain_text", "text": truncate(text, 150)}
+}
+
+func mrkdwn(text string) map[string]any {
+	return map[string]any{"type": "mrkdwn", "text": text}
+}
+
+func section(text string) map[string]any {
+	return map[string]any{"type": "section", "text": mrkdwn(text)}
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,288 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newChatReceiver returns an incoming webhook that records the JSON payloads posted to it.
+func newChatReceiver(t *testing.T) (*httptest.Server, func() []map[string]any) {
+	t.Helper()
+	var (
+		mu       sync.Mutex
+		payloads []map[string]any
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p map[string]any
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "not json", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		payloads = append(payloads, p)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []map[string]any {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(payloads)
+	}
+}
+
+// chatWords returns n words named w1 to wn.
+func chatWords(n int) []entity.MongoMessage {
+	words := make([]entity.MongoMessage, n)
+	for i := range words {
+		words[i] = entity.MongoMessage{
+			Word: fmt.Sprintf("w%d", i+1), Translation: fmt.Sprintf("t%d", i+1),
+		}
+	}
+	return words
+}
+
+// stream passes the words to fn like the digest stream of a delivery.
+func stream(words []entity.MongoMessage) func(fn func(entity.MongoMessage) error) error {
+	return func(fn func(entity.MongoMessage) error) error {
+		for _, w := range words {
+			if err := fn(w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestChatHighlight(t *testing.T) {
+	c := &Chat{highlight: []config.Highlight{
+		{Tag: "idiom", Source: "bot", Emoji: ":speech_balloon:"},
+		{Lang: "de-ru", Emoji: ":de:"},
+		{Tag: "idiom"},
+	}}
+	tests := []struct {
+		name  string
+		word  entity.MongoMessage
+		emoji string
+	}{
+		{
+			name:  "every field of the first rule",
+			word:  entity.MongoMessage{Tags: []string{"verb", "idiom"}, Source: "bot"},
+			emoji: ":speech_balloon:",
+		},
+		{
+			name:  "language pair",
+			word:  entity.MongoMessage{Source: "bot", LangFrom: "de", LangTo: "ru"},
+			emoji: ":de:",
+		},
+		{
+			name:  "default emoji",
+			word:  entity.MongoMessage{Tags: []string{"idiom"}, Source: "web"},
+			emoji: defaultEmoji,
+		},
+		{name: "reversed language pair", word: entity.MongoMessage{LangFrom: "ru", LangTo: "de"}},
+		{name: "no rule", word: entity.MongoMessage{Tags: []string{"verb"}, Source: "bot"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emoji, ok := c.match(tt.word)
+			if emoji != tt.emoji || ok != (tt.emoji != "") {
+				t.Errorf("match = %q, %v, want %q", emoji, ok, tt.emoji)
+			}
+		})
+	}
+}
+
+func TestChatPick(t *testing.T) {
+	words := chatWords(100)
+	words[70].Tags = []string{"idiom"}
+	words[90].Tags = []string{"idiom"}
+	c := &Chat{
+		name: "chat", sample: 5, featured: true,
+		highlight: []config.Highlight{{Tag: "idiom"}},
+	}
+
+	// Words beyond the first ones the delivery carries are picked too.
+	d := Delivery{DigestID: "d1", Words: words[:10], Stream: stream(words)}
+	featured, picked, err := c.pick(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if featured == nil || featured.word.Word != "w71" || featured.emoji != defaultEmoji {
+		t.Fatalf("word of the day = %+v, want the first highlighted word", featured)
+	}
+	if len(picked) != 5 {
+		t.Fatalf("picked %d words, want the sample of 5", len(picked))
+	}
+	if picked[0].word.Word != "w91" {
+		t.Errorf("first word = %s, want the other highlighted word", picked[0].word.Word)
+	}
+	var positions []int
+	for _, w := range picked[1:] {
+		var pos int
+		fmt.Sscanf(w.word.Word, "w%d", &pos)
+		if pos == 71 || pos == 91 {
+			t.Errorf("%s picked twice", w.word.Word)
+		}
+		positions = append(positions, pos)
+	}
+	if !slices.IsSorted(positions) {
+		t.Errorf("words at %v, want digest order", positions)
+	}
+
+	// Every attempt of the digest posts the same words, another digest may not.
+	again, pickedAgain, err := c.pick(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.word.Word != featured.word.Word || !reflect.DeepEqual(pickedAgain, picked) {
+		t.Errorf("another attempt picked %v, want %v", pickedAgain, picked)
+	}
+	other := false
+	for i := range 10 {
+		d.DigestID = fmt.Sprintf("d%d", i+2)
+		_, p, err := c.pick(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other = other || !reflect.DeepEqual(p, picked)
+	}
+	if !other {
+		t.Error("every digest picked the same words")
+	}
+}
+
+func TestChatPickRandomWordOfTheDay(t *testing.T) {
+	c := &Chat{name: "chat", sample: 10, featured: true}
+	d := Delivery{DigestID: "d1", Stream: stream(chatWords(3))}
+	featured, picked, err := c.pick(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if featured == nil || len(picked) != 2 {
+		t.Fatalf("picked %v and %v, want a word of the day and the two others", featured, picked)
+	}
+	for _, w := range picked {
+		if w.word.Word == featured.word.Word {
+			t.Errorf("word of the day %s is in the sample", w.word.Word)
+		}
+	}
+	again, _, err := c.pick(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.word.Word != featured.word.Word {
+		t.Errorf("word of the day changed from %s to %s", featured.word.Word, again.word.Word)
+	}
+}
+
+// chatDelivery is a digest of 5 words whose 3 first words are recorded, the second one
+// highlighted, all of them with characters the chat markup has to escape.
+func chatDelivery() Delivery {
+	words := []entity.MongoMessage{
+		{Word: "a<b>", Translation: "а & б"},
+		{Word: "*star*", Translation: "звезда", Example: "_x_\ny", Tags: []string{"idiom"}},
+		{Word: "c", Translation: "ц"},
+	}
+	return Delivery{DigestID: "d1", Subject: "Words", Count: 5, Stream: stream(words)}
+}
+
+func TestChatSlackPayload(t *testing.T) {
+	srv, received := newChatReceiver(t)
+	c, err := NewChat("slack", config.ChatSink{
+		URL: srv.URL, Username: "digest", WordOfTheDay: true,
+		Highlight: []config.Highlight{{Tag: "idiom", Emoji: ":bulb:"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Deliver(context.Background(), chatDelivery()); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	want := map[string]any{
+		"text":     "Words: 5 words",
+		"username": "digest",
+		"blocks": []any{
+			map[string]any{
+				"type": "header",
+				"text": map[string]any{"type": "plain_text", "text": "Words: 5 words"},
+			},
+			map[string]any{"type": "section", "text": map[string]any{
+				"type": "mrkdwn", "text": "*Word of the day:* **star** — звезда\n__x_\ny_",
+			}},
+			map[string]any{"type": "divider"},
+			map[string]any{"type": "section", "text": map[string]any{
+				"type": "mrkdwn", "text": "*a&lt;b&gt;* — а &amp; б\n*c* — ц",
+			}},
+			map[string]any{"type": "context", "elements": []any{
+				map[string]any{"type": "mrkdwn", "text": "… and 2 more in the export."},
+			}},
+		},
+	}
+	payloads := received()
+	if len(payloads) != 1 || !reflect.DeepEqual(payloads[0], want) {
+		t.Errorf("payloads = %#v, want %#v", payloads, want)
+	}
+}
+
+func TestChatMattermostPayload(t *testing.T) {
+	srv, received := newChatReceiver(t)
+	c, err := NewChat("mattermost", config.ChatSink{
+		URL: srv.URL, Style: ChatMattermost,
+		Highlight: []config.Highlight{{Tag: "idiom", Emoji: ":bulb:"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Deliver(context.Background(), chatDelivery()); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"#### Words: 5 words",
+		`- :bulb: **\*star\*** — звезда  ` + "\n" + `  *\_x\_ y*`,
+		`- **a\<b\>** — а & б`,
+		"- **c** — ц",
+		"",
+		"*… and 2 more in the export.*",
+	}, "\n")
+	payloads := received()
+	if len(payloads) != 1 || !reflect.DeepEqual(payloads[0], map[string]any{"text": want}) {
+		t.Errorf("payloads = %#v, want text %q", payloads, want)
+	}
+}
+
+func TestChatQuizLeavesOutAnswers(t *testing.T) {
+	srv, received := newChatReceiver(t)
+	c, err := NewChat("mattermost", config.ChatSink{
+		URL: srv.URL, Style: ChatMattermost, WordOfTheDay: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := chatDelivery()
+	d.Quiz = true
+	if err := c.Deliver(context.Background(), d); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	want := map[string]any{"text": "#### Words: 5 words"}
+	if payloads := received(); len(payloads) != 1 || !reflect.DeepEqual(payloads[0], want) {
+		t.Errorf("payloads = %#v, want the headline only", payloads)
+	}
+}
@@ -0,0 +1,74 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Directory copies the export file into a local or mounted directory. The copy is written
+// under a temporary name and renamed, so readers of the directory never see a partial file.
+// Deliveries without an export file leave the directory as it is.
+type Directory struct {
+	name string
+	path string
+}
+
+func NewDirectory(name string, cfg config.DirectorySink) (*Directory, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("directory path is empty")
+	}
+	if err := os.MkdirAll(cfg.Path, 0o755); err != nil {
+		return nil, err
+	}
+	return &Directory{name: name, path: cfg.Path}, nil
+}
+
+func (d *Directory) Name() string {
+	return d.name
+}
+
+func (d *Directory) Deliver(_ context.Context, delivery Delivery) error {
+	const op = "sink.Directory.Deliver"
+	if delivery.File == "" {
+		return nil
+	}
+	if err := d.copy(delivery.File); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (d *Directory) copy(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(d.path, ".export-word-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), filepath.Join(d.path, filepath.Base(src)))
+}
@@ -0,0 +1,59 @@
+package sink
+
+import (
+	"context"
+	"github.com/fentezi/export-word/internal/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectoryDeliver(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "words-2026-01-02.txt")
+	if err := os.WriteFile(src, []byte("apple;яблоко\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The directory is created on demand.
+	dir := filepath.Join(t.TempDir(), "share", "words")
+	d, err := NewDirectory("share", config.DirectorySink{Path: dir})
+	if err != nil {
+		t.Fatalf("NewDirectory: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := d.Deliver(ctx, Delivery{DigestID: "d1", File: src}); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	// A delivery without the export file leaves the directory as it is.
+	if err := d.Deliver(ctx, Delivery{DigestID: "d2"}); err != nil {
+		t.Fatalf("Deliver without a file: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(src) {
+		t.Fatalf("directory holds %v, want only the copy and no temporary file", entries)
+	}
+	dst := filepath.Join(dir, entries[0].Name())
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "apple;яблоко\n" {
+		t.Errorf("copy = %q", data)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o644 {
+		t.Errorf("copy mode = %v, want 0644", mode)
+	}
+
+	// A missing export file fails the delivery, so that the outbox retries it.
+	if err := d.Deliver(ctx, Delivery{File: src + ".missing"}); err == nil {
+		t.Error("Deliver of a missing file succeeded")
+	}
+}
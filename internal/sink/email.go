@@ -0,0 +1,97 @@
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/fentezi/export-word/internal/gmail"
+	"strings"
+)
+
+// Mailer sends email messages.
+type Mailer interface {
+	SendMessage(message gmail.Message) error
+}
+
+// Limiter admits mail to n recipients. Reserve returns a *DeferredError when the mail has to
+// wait; Release gives back a reservation whose mail was not sent.
+type Limiter interface {
+	Reserve(ctx context.Context, n int) error
+	Release(ctx context.Context, n int) error
+}
+
+// Mail is what email sinks send with.
+type Mail struct {
+	From   string
+	Mailer Mailer
+	// Limiter admits every message when set.
+	Limiter Limiter
+	// Keys are the OpenPGP keys of the recipients whose digests are encrypted, by address.
+	Keys map[string]openpgp.EntityList
+}
+
+// Email mails the digest to the recipient with the export file attached, encrypted with
+// PGP/MIME when the recipient has a key.
+type Email struct {
+	name string
+	mail Mail
+}
+
+func NewEmail(name string, mail Mail) *Email {
+	return &Email{name: name, mail: mail}
+}
+
+func (e *Email) Name() string {
+	return e.name
+}
+
+func (e *Email) Deliver(ctx context.Context, d Delivery) error {
+	const op = "sink.Email.Deliver"
+
+	if e.mail.Limiter != nil {
+		if err := e.mail.Limiter.Reserve(ctx, 1); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	msg := gmail.Message{
+		From:      e.mail.From,
+		To:        []string{d.Recipient},
+		Subject:   d.Subject,
+		Body:      d.Content.HTML,
+		File:      d.File,
+		EncryptTo: e.mail.Keys[d.Recipient],
+	}
+	if d.DigestID != "" {
+		domain := gmail.Domain(e.mail.From)
+		msg.MessageID = messageID(d, domain)
+		msg.References = []string{"<" + d.DigestID + "@" + domain + ">"}
+	}
+	for _, a := range d.Content.Attachments {
+		msg.Attachments = append(msg.Attachments, gmail.Attachment{Name: a.Name, Data: a.Data})
+	}
+	for _, e := range d.Content.Embeds {
+		msg.Embeds = append(msg.Embeds, gmail.Attachment{Name: e.Name, Data: e.Data})
+	}
+	if err := e.mail.Mailer.SendMessage(msg); err != nil {
+		// Mail that was not sent does not count against the rate or the quota.
+		if e.mail.Limiter != nil {
+			if rerr := e.mail.Limiter.Release(ctx, 1); rerr != nil {
+				err = errors.Join(err, rerr)
+			}
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+// messageID derives the Message-ID from the digest ID, the recipient and the time the delivery
+// was queued: retries of a delivery keep it, so that a message sent twice is recognized as
+// one, while a resend gets a new one. Every message of a digest references the digest ID,
+// which threads the original and its resends together.
+func messageID(d Delivery, domain string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(d.Recipient)))
+	return fmt.Sprintf("<%s.%x.%d@%s>", d.DigestID, sum[:4], d.CreatedAt.Unix(), domain)
+}
@@ -0,0 +1,66 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"github.com/fentezi/export-word/internal/gmail"
+	"testing"
+)
+
+// countingLimiter admits every mail and counts the recipients reserved and released.
+type countingLimiter struct {
+	reserved, released int
+}
+
+func (l *countingLimiter) Reserve(ctx context.Context, n int) error {
+	l.reserved += n
+	return nil
+}
+
+func (l *countingLimiter) Release(ctx context.Context, n int) error {
+	l.released += n
+	return nil
+}
+
+// mailerFunc sends a message with a function.
+type mailerFunc func(gmail.Message) error
+
+func (f mailerFunc) SendMessage(message gmail.Message) error {
+	return f(message)
+}
+
+func TestEmailReleasesUnsentMail(t *testing.T) {
+	unreachable := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		err      error
+		released int
+	}{
+		{name: "sent"},
+		{name: "send failed", err: unreachable, released: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &countingLimiter{}
+			e := NewEmail("email", Mail{
+				From:    "digest@example.com",
+				Mailer:  mailerFunc(func(gmail.Message) error { return tt.err }),
+				Limiter: limiter,
+			})
+
+			err := e.Deliver(context.Background(), Delivery{
+				DigestID: "d1", Recipient: "user@example.com", Subject: "Words",
+			})
+			if !errors.Is(err, tt.err) || (err == nil) != (tt.err == nil) {
+				t.Fatalf("Deliver = %v, want %v", err, tt.err)
+			}
+			if limiter.reserved != 1 || limiter.released != tt.released {
+				t.Errorf(
+					"reserved %d and released %d, want 1 and %d",
+					limiter.reserved, limiter.released, tt.released,
+				)
+			}
+		})
+	}
+}
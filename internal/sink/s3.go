@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// S3 uploads the export file to an S3-compatible bucket. The digest ID and word count are
+// stored as object metadata. Deliveries without an export file upload nothing.
+type S3 struct {
+	name   string
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3(name string, cfg config.S3Sink) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+	secret, err := readSecret(cfg.SecretKey, cfg.SecretKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, secret, ""),
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3{name: name, client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+func (s *S3) Name() string {
+	return s.name
+}
+
+func (s *S3) Deliver(ctx context.Context, d Delivery) error {
+	const op = "sink.S3.Deliver"
+	if d.File == "" {
+		return nil
+	}
+
+	file, err := os.Open(d.File)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(d.File))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	key := path.Join(s.prefix, filepath.Base(d.File))
+	_, err = s.client.PutObject(ctx, s.bucket, key, file, info.Size(), minio.PutObjectOptions{
+		ContentType: contentType,
+		UserMetadata: map[string]string{
+			"Digest-Id": d.DigestID,
+			"Count":     strconv.Itoa(d.Count),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/fentezi/export-word/internal/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// s3Object is an object stored by the S3 stand-in.
+type s3Object struct {
+	header http.Header
+	data   []byte
+}
+
+// fakeS3 is a MinIO-style stand-in that keeps the objects put into its buckets in memory. It
+// checks that requests are signed with the access key and answers anything but a PUT of an
+// object with 501.
+type fakeS3 struct {
+	accessKey string
+
+	mu      sync.Mutex
+	objects map[string]s3Object
+}
+
+func newFakeS3(t *testing.T, accessKey string) (*fakeS3, *httptest.Server) {
+	t.Helper()
+	f := &fakeS3{accessKey: accessKey, objects: make(map[string]s3Object)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential="+f.accessKey+"/") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPut || strings.Count(strings.Trim(r.URL.Path, "/"), "/") < 1 {
+		http.Error(w, "NotImplemented", http.StatusNotImplemented)
+		return
+	}
+	data, err := readS3Body(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.objects[strings.TrimPrefix(r.URL.Path, "/")] = s3Object{header: r.Header, data: data}
+	f.mu.Unlock()
+	w.Header().Set("ETag", `"etag"`)
+}
+
+// object returns the object stored under "bucket/key".
+func (f *fakeS3) object(path string) (s3Object, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[path]
+	return obj, ok
+}
+
+// readS3Body returns the payload of a PUT, decoding the aws-chunked encoding of a streaming
+// signature.
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var data bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return data.Bytes(), nil
+		}
+		if _, err := io.CopyN(&data, br, n); err != nil {
+			return nil, err
+		}
+		if _, err := br.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func TestS3Deliver(t *testing.T) {
+	store, srv := newFakeS3(t, "minio")
+	file := filepath.Join(t.TempDir(), "words-2026-01-02.xlsx")
+	if err := os.WriteFile(file, []byte("workbook"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewS3("bucket", config.S3Sink{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "words",
+		Prefix:    "digests",
+		AccessKey: "minio",
+		SecretKey: "minio123",
+		Insecure:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := s.Deliver(ctx, Delivery{DigestID: "d1", File: file, Count: 3}); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	obj, ok := store.object("words/digests/words-2026-01-02.xlsx")
+	if !ok {
+		t.Fatalf("object not stored, have %v", store.objects)
+	}
+	if string(obj.data) != "workbook" {
+		t.Errorf("object = %q", obj.data)
+	}
+	want := map[string]string{
+		"Content-Type":         "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"X-Amz-Meta-Digest-Id": "d1",
+		"X-Amz-Meta-Count":     "3",
+	}
+	for name, value := range want {
+		if got := obj.header.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	// A delivery without the export file uploads nothing.
+	if err := s.Deliver(ctx, Delivery{DigestID: "d2"}); err != nil {
+		t.Fatalf("Deliver without a file: %v", err)
+	}
+	if len(store.objects) != 1 {
+		t.Errorf("%d objects stored, want 1", len(store.objects))
+	}
+}
+
+func TestS3WrongCredentials(t *testing.T) {
+	_, srv := newFakeS3(t, "minio")
+	file := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(file, []byte("apple;яблоко\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewS3("bucket", config.S3Sink{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    "words",
+		AccessKey: "other",
+		SecretKey: "secret",
+		Insecure:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	if err := s.Deliver(context.Background(), Delivery{File: file}); err == nil {
+		t.Fatal("Deliver with the wrong access key succeeded")
+	}
+}
@@ -0,0 +1,123 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/digest"
+	"github.com/fentezi/export-word/internal/entity"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	TypeEmail     = "email"
+	TypeDirectory = "directory"
+	TypeS3        = "s3"
+	TypeWebhook   = "webhook"
+	TypeTelegram  = "telegram"
+	TypeChat      = "chat"
+)
+
+// fieldLimit caps every field of a word rendered by a chat sink, so that a line, escaped and
+// with its markup, always fits in one message.
+const fieldLimit = 200
+
+var (
+	ErrUnknownType = errors.New("unknown sink type")
+	ErrUnknownSink = errors.New("unknown sink")
+)
+
+// DeferredError is returned by a sink that cannot deliver before Until, such as a mail sender
+// over its rate or daily quota. The delivery is retried then without counting an attempt.
+type DeferredError struct {
+	Until  time.Time
+	Reason string
+}
+
+func (e *DeferredError) Error() string {
+	return fmt.Sprintf("deferred until %s: %s", e.Until.Format(time.RFC3339), e.Reason)
+}
+
+// Delivery is the digest of one subscriber, ready to leave the service. A shared sink gets a
+// single delivery per digest with an empty Recipient.
+type Delivery struct {
+	DigestID  string
+	Recipient string
+	Subject   string
+	// File is the path of the export file in the workspace, empty when the subscriber does not
+	// receive it.
+	File    string
+	Count   int
+	Content digest.Content
+	// Words are the first words of the digest, for sinks that render it themselves.
+	Words []entity.MongoMessage
+	// Stream, when set, passes every word of the digest to fn in digest order, for sinks that
+	// sample the whole digest rather than its first words.
+	Stream func(fn func(entity.MongoMessage) error) error
+	// Quiz is set when the digest is a quiz to a recipient of the delivery. Sinks that render
+	// the words then leave them out, as the translations are the answers.
+	Quiz      bool
+	CreatedAt time.Time
+}
+
+// Sink delivers digests to a destination.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, d Delivery) error
+}
+
+// Shared reports whether the sink posts to a destination its subscribers share, such as a
+// group chat, so that a digest is delivered to it once rather than once per subscriber.
+func Shared(s Sink) bool {
+	shared, ok := s.(interface{ Shared() bool })
+	return ok && shared.Shared()
+}
+
+// New returns the sink of the configured type. Email sinks send with mail.
+func New(cfg config.Sink, mail Mail) (Sink, error) {
+	const op = "sink.New"
+
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("%s: sink name is empty", op)
+	}
+
+	var (
+		s   Sink
+		err error
+	)
+	switch cfg.Type {
+	case TypeEmail:
+		s = NewEmail(cfg.Name, mail)
+	case TypeDirectory:
+		s, err = NewDirectory(cfg.Name, cfg.Directory)
+	case TypeS3:
+		s, err = NewS3(cfg.Name, cfg.S3)
+	case TypeWebhook:
+		s, err = NewWebhook(cfg.Name, cfg.Webhook)
+	case TypeTelegram:
+		s, err = NewTelegram(cfg.Name, cfg.Telegram)
+	case TypeChat:
+		s, err = NewChat(cfg.Name, cfg.Chat)
+	default:
+		err = fmt.Errorf("%w: %q", ErrUnknownType, cfg.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", op, cfg.Name, err)
+	}
+	return s, nil
+}
+
+// readSecret returns value, or the trimmed contents of file when value is empty.
+func readSecret(value, file string) (string, error) {
+	if value != "" || file == "" {
+		return value, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,228 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"html"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultTelegramURL = "https://api.telegram.org"
+	// telegramMessageLimit stays below the 4096 characters Telegram allows per message, leaving
+	// room for the markup that is counted before parsing.
+	telegramMessageLimit = 4000
+	telegramCaptionLimit = 1024
+)
+
+// Telegram sends the digest to a chat through the Bot API: the words as HTML-formatted
+// messages, split to stay under the message size limit, followed by the export file as a
+// document when the subscriber receives it. The chat is shared by the subscribers that list
+// the sink.
+type Telegram struct {
+	name    string
+	baseURL string
+	chatID  string
+	client  *http.Client
+}
+
+// telegramResponse is the envelope of every Bot API response.
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+	ErrorCode   int    `json:"error_code"`
+}
+
+func NewTelegram(name string, cfg config.TelegramSink) (*Telegram, error) {
+	token, err := readSecret(cfg.Token, cfg.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" || cfg.ChatID == "" {
+		return nil, errors.New("telegram token and chat id are required")
+	}
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = defaultTelegramURL
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	return &Telegram{
+		name:    name,
+		baseURL: strings.TrimRight(baseURL, "/") + "/bot" + token,
+		chatID:  cfg.ChatID,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (t *Telegram) Name() string {
+	return t.name
+}
+
+func (t *Telegram) Shared() bool {
+	return true
+}
+
+func (t *Telegram) Deliver(ctx context.Context, d Delivery) error {
+	const op = "sink.Telegram.Deliver"
+
+	for _, text := range chunkLines(telegramLines(d), telegramMessageLimit) {
+		if err := t.sendMessage(ctx, text); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if d.File == "" {
+		return nil
+	}
+	if err := t.sendDocument(ctx, d); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
+
+func (t *Telegram) sendMessage(ctx context.Context, text string) error {
+	body, err := json.Marshal(map[string]any{
+		"chat_id":                  t.chatID,
+		"text":                     text,
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+	return t.call(ctx, "sendMessage", "application/json", bytes.NewReader(body))
+}
+
+func (t *Telegram) sendDocument(ctx context.Context, d Delivery) error {
+	file, err := os.Open(d.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("chat_id", t.chatID); err != nil {
+		return err
+	}
+	caption := fmt.Sprintf("%s: %d words", d.Subject, d.Count)
+	if err := mw.WriteField("caption", truncate(caption, telegramCaptionLimit)); err != nil {
+		return err
+	}
+	part, err := mw.CreateFormFile("document", filepath.Base(d.File))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	return t.call(ctx, "sendDocument", mw.FormDataContentType(), &buf)
+}
+
+// call invokes the Bot API method and turns a response with ok=false into an error.
+func (t *Telegram) call(ctx context.Context, method, contentType string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+"/"+method, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// The request URL contains the bot token.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var res telegramResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&res); err != nil {
+		return fmt.Errorf("%s: %s: %w", method, resp.Status, err)
+	}
+	if !res.OK {
+		return fmt.Errorf("%s: %d %s", method, res.ErrorCode, res.Description)
+	}
+	return nil
+}
+
+// telegramLines renders the digest as lines of Telegram HTML, only the headline for a quiz.
+func telegramLines(d Delivery) []string {
+	headline := fmt.Sprintf("<b>%s</b>: %d words", html.EscapeString(d.Subject), d.Count)
+	if d.Quiz {
+		return []string{headline}
+	}
+	lines := []string{headline, ""}
+	field := func(s string) string {
+		return html.EscapeString(truncate(s, fieldLimit))
+	}
+	for _, w := range d.Words {
+		line := fmt.Sprintf("<b>%s</b> — %s", field(w.Word), field(w.Translation))
+		if w.Example != "" {
+			line += "\n<i>" + field(w.Example) + "</i>"
+		}
+		lines = append(lines, line)
+	}
+	if more := d.Count - len(d.Words); more > 0 {
+		if d.File != "" {
+			lines = append(lines, "", fmt.Sprintf("… and %d more in the file.", more))
+		} else {
+			lines = append(lines, "", fmt.Sprintf("… and %d more.", more))
+		}
+	}
+	return lines
+}
+
+// chunkLines joins the lines into texts of at most limit characters, splitting only between
+// lines. Lines must not be longer than the limit.
+func chunkLines(lines []string, limit int) []string {
+	var (
+		chunks []string
+		cur    strings.Builder
+		size   int
+	)
+	for _, line := range lines {
+		n := utf8.RuneCountInString(line)
+		if size > 0 && size+1+n > limit {
+			chunks = append(chunks, cur.String())
+			cur.Reset()
+			size = 0
+		}
+		if size > 0 {
+			cur.WriteByte('\n')
+			size++
+		}
+		cur.WriteString(line)
+		size += n
+	}
+	if strings.TrimSpace(cur.String()) != "" {
+		chunks = append(chunks, cur.String())
+	}
+	return chunks
+}
+
+// truncate shortens s to at most limit characters.
+func truncate(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	return string([]rune(s)[:limit-1]) + "…"
+}
@@ -0,0 +1,218 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/entity"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// botCall is a Bot API call received by the fake server.
+type botCall struct {
+	method string
+	chatID string
+	text   string
+	// caption and document are the fields of sendDocument, document as "name=content".
+	caption  string
+	document string
+}
+
+// fakeBot is a stand-in for the Bot API that records the calls made with its token and
+// answers the others like Telegram answers a wrong token.
+type fakeBot struct {
+	mu    sync.Mutex
+	calls []botCall
+}
+
+func newFakeBot(t *testing.T, token string) (*fakeBot, *httptest.Server) {
+	t.Helper()
+	bot := &fakeBot{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, ok := strings.CutPrefix(r.URL.Path, "/bot"+token+"/")
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"ok":false,"error_code":401,"description":"Unauthorized"}`)
+			return
+		}
+		call := botCall{method: method}
+		switch method {
+		case "sendMessage":
+			var req struct {
+				ChatID    string `json:"chat_id"`
+				Text      string `json:"text"`
+				ParseMode string `json:"parse_mode"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ParseMode != "HTML" {
+				http.Error(w, `{"ok":false,"error_code":400}`, http.StatusBadRequest)
+				return
+			}
+			call.chatID, call.text = req.ChatID, req.Text
+		case "sendDocument":
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				http.Error(w, `{"ok":false,"error_code":400}`, http.StatusBadRequest)
+				return
+			}
+			call.chatID, call.caption = r.FormValue("chat_id"), r.FormValue("caption")
+			f, h, err := r.FormFile("document")
+			if err != nil {
+				http.Error(w, `{"ok":false,"error_code":400}`, http.StatusBadRequest)
+				return
+			}
+			data, _ := io.ReadAll(f)
+			f.Close()
+			call.document = h.Filename + "=" + string(data)
+		}
+		bot.mu.Lock()
+		bot.calls = append(bot.calls, call)
+		bot.mu.Unlock()
+		fmt.Fprint(w, `{"ok":true,"result":{}}`)
+	}))
+	t.Cleanup(srv.Close)
+	return bot, srv
+}
+
+func TestTelegramDeliver(t *testing.T) {
+	bot, srv := newFakeBot(t, "123:abc")
+	tg, err := NewTelegram("telegram", config.TelegramSink{
+		Token: "123:abc", ChatID: "-100", BaseURL: srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "words.csv")
+	if err := os.WriteFile(file, []byte("apple,яблоко\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = tg.Deliver(context.Background(), Delivery{
+		DigestID: "d1",
+		Subject:  "Words <today>",
+		File:     file,
+		Count:    2,
+		Words: []entity.MongoMessage{
+			{Word: "apple", Translation: "яблоко", Example: "A & B"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	want := []botCall{
+		{
+			method: "sendMessage",
+			chatID: "-100",
+			text: "<b>Words &lt;today&gt;</b>: 2 words\n\n<b>apple</b> — яблоко\n" +
+				"<i>A &amp; B</i>\n\n… and 1 more in the file.",
+		},
+		{
+			method:   "sendDocument",
+			chatID:   "-100",
+			caption:  "Words <today>: 2 words",
+			document: "words.csv=apple,яблоко\n",
+		},
+	}
+	if len(bot.calls) != len(want) {
+		t.Fatalf("calls = %+v, want %+v", bot.calls, want)
+	}
+	for i := range want {
+		if bot.calls[i] != want[i] {
+			t.Errorf("call %d = %+v, want %+v", i, bot.calls[i], want[i])
+		}
+	}
+}
+
+func TestTelegramQuizLeavesOutAnswers(t *testing.T) {
+	bot, srv := newFakeBot(t, "123:abc")
+	tg, err := NewTelegram("telegram", config.TelegramSink{
+		Token: "123:abc", ChatID: "-100", BaseURL: srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tg.Deliver(context.Background(), Delivery{
+		Subject: "Words",
+		Count:   1,
+		Words:   []entity.MongoMessage{{Word: "apple", Translation: "яблоко"}},
+		Quiz:    true,
+	})
+	if err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	if len(bot.calls) != 1 || bot.calls[0].text != "<b>Words</b>: 1 words" {
+		t.Errorf("calls = %+v, want the headline only", bot.calls)
+	}
+}
+
+func TestTelegramWrongToken(t *testing.T) {
+	_, srv := newFakeBot(t, "123:abc")
+	tg, err := NewTelegram("telegram", config.TelegramSink{
+		Token: "123:wrong", ChatID: "-100", BaseURL: srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tg.Deliver(context.Background(), Delivery{Subject: "Words", Count: 1})
+	if err == nil || !strings.Contains(err.Error(), "401 Unauthorized") {
+		t.Fatalf("Deliver = %v, want the API error", err)
+	}
+	if strings.Contains(err.Error(), "wrong") {
+		t.Errorf("error %q shows the token", err)
+	}
+}
+
+func TestChunkLines(t *testing.T) {
+	// Cyrillic lines take two bytes per character; the limit counts characters.
+	line := strings.Repeat("я", 999)
+	tests := []struct {
+		name  string
+		lines []string
+		want  []int
+	}{
+		{
+			name:  "exactly the limit",
+			lines: []string{line, line, line, line + "я"},
+			want:  []int{telegramMessageLimit},
+		},
+		{
+			name:  "one character over",
+			lines: []string{line, line, line, line + "яя"},
+			want:  []int{2999, 1001},
+		},
+		{
+			name:  "a line of the limit",
+			lines: []string{"a", strings.Repeat("я", telegramMessageLimit), "b"},
+			want:  []int{1, telegramMessageLimit, 1},
+		},
+		{name: "blank lines only", lines: []string{"", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkLines(tt.lines, telegramMessageLimit)
+			var got []int
+			for _, c := range chunks {
+				got = append(got, utf8.RuneCountInString(c))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("chunk sizes = %v, want %v", got, tt.want)
+			}
+			// Splitting happens only between lines, so nothing is lost.
+			if joined := strings.Join(chunks, "\n"); len(chunks) > 0 &&
+				joined != strings.Join(tt.lines, "\n") {
+				t.Errorf("chunks do not join to the lines")
+			}
+		})
+	}
+}
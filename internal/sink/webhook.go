@@ -0,0 +1,174 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout = 30 * time.Second
+
+	HeaderTimestamp = "X-Export-Word-Timestamp"
+	HeaderSignature = "X-Export-Word-Signature"
+)
+
+// webhookMetadata is the "metadata" part of the request.
+type webhookMetadata struct {
+	DigestID  string    `json:"digest_id"`
+	Recipient string    `json:"recipient"`
+	Subject   string    `json:"subject"`
+	Count     int       `json:"count"`
+	FileName  string    `json:"file_name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Webhook posts the digest as multipart/form-data with a "metadata" JSON part, the export
+// file, when the subscriber receives it, as "file" and the subscriber's attachments as
+// "attachment". Requests carry the Unix time in X-Export-Word-Timestamp and "sha256="
+// followed by the hex HMAC-SHA256 of the timestamp, a dot and the body in
+// X-Export-Word-Signature.
+type Webhook struct {
+	name   string
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func NewWebhook(name string, cfg config.WebhookSink) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("webhook url is empty")
+	}
+	secret, err := readSecret(cfg.Secret, cfg.SecretFile)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		return nil, errors.New("webhook secret is empty")
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	return &Webhook{
+		name:   name,
+		url:    cfg.URL,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (w *Webhook) Name() string {
+	return w.name
+}
+
+func (w *Webhook) Deliver(ctx context.Context, d Delivery) error {
+	const op = "sink.Webhook.Deliver"
+
+	body, contentType, err := w.body(d)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(w.secret, timestamp, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: unexpected status %s", op, resp.Status)
+	}
+	return nil
+}
+
+// body renders the multipart request body. It is buffered so that it can be signed.
+func (w *Webhook) body(d Delivery) ([]byte, string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	meta := webhookMetadata{
+		DigestID:  d.DigestID,
+		Recipient: d.Recipient,
+		Subject:   d.Subject,
+		Count:     d.Count,
+		CreatedAt: d.CreatedAt,
+	}
+	if d.File != "" {
+		meta.FileName = filepath.Base(d.File)
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := mw.WriteField("metadata", string(data)); err != nil {
+		return nil, "", err
+	}
+
+	if d.File != "" {
+		if err := writeFormFile(mw, "file", d.File); err != nil {
+			return nil, "", err
+		}
+	}
+
+	for _, a := range d.Content.Attachments {
+		part, err := mw.CreateFormFile("attachment", a.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(a.Data); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), mw.FormDataContentType(), nil
+}
+
+// writeFormFile copies the file at path into a form part named field.
+func writeFormFile(mw *multipart.Writer, field, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	part, err := mw.CreateFormFile(field, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	return err
+}
+
+// Sign returns the hex HMAC-SHA256 of the timestamp and body, as sent in the signature header.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,139 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"github.com/fentezi/export-word/internal/config"
+	"github.com/fentezi/export-word/internal/digest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// webhookRequest is a request received by the test receiver.
+type webhookRequest struct {
+	metadata webhookMetadata
+	// files holds the uploaded parts by form field, as "name=content".
+	files map[string][]string
+}
+
+// newWebhookReceiver returns a server that checks the signature of every request the way a
+// receiver is meant to: it recomputes the HMAC of the timestamp header, a dot and the raw body
+// and compares it in constant time. Requests signed wrongly are answered with 401.
+func newWebhookReceiver(
+	t *testing.T, secret string, received chan<- webhookRequest,
+) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		timestamp := r.Header.Get(HeaderTimestamp)
+		if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+			http.Error(w, "bad timestamp", http.StatusUnauthorized)
+			return
+		}
+		want := "sha256=" + Sign([]byte(secret), timestamp, body)
+		if !hmac.Equal([]byte(r.Header.Get(HeaderSignature)), []byte(want)) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req webhookRequest
+		if err := json.Unmarshal([]byte(r.FormValue("metadata")), &req.metadata); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.files = make(map[string][]string)
+		for field, headers := range r.MultipartForm.File {
+			for _, h := range headers {
+				f, err := h.Open()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				data, _ := io.ReadAll(f)
+				f.Close()
+				req.files[field] = append(req.files[field], h.Filename+"="+string(data))
+			}
+		}
+		received <- req
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	const secret = "s3cret"
+	received := make(chan webhookRequest, 1)
+	srv := newWebhookReceiver(t, secret, received)
+
+	file := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(file, []byte("apple;яблоко\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wh, err := NewWebhook("hook", config.WebhookSink{URL: srv.URL, Secret: secret})
+	if err != nil {
+		t.Fatalf("NewWebhook: %v", err)
+	}
+	created := time.Date(2026, 1, 2, 8, 0, 0, 0, time.UTC)
+	d := Delivery{
+		DigestID:  "d1",
+		Recipient: "list@example.com",
+		Subject:   "Dictionary",
+		File:      file,
+		Count:     1,
+		Content: digest.Content{
+			Attachments: []digest.Attachment{{Name: "answers.txt", Data: []byte("яблоко")}},
+		},
+		CreatedAt: created,
+	}
+
+	if err := wh.Deliver(context.Background(), d); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	req := <-received
+	want := webhookMetadata{
+		DigestID: "d1", Recipient: "list@example.com", Subject: "Dictionary", Count: 1,
+		FileName: "words.txt", CreatedAt: created,
+	}
+	if !req.metadata.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("created_at = %v, want %v", req.metadata.CreatedAt, want.CreatedAt)
+	}
+	req.metadata.CreatedAt = created
+	if req.metadata != want {
+		t.Errorf("metadata = %+v, want %+v", req.metadata, want)
+	}
+	if got := req.files["file"]; len(got) != 1 || got[0] != "words.txt=apple;яблоко\n" {
+		t.Errorf("file parts = %q", got)
+	}
+	if got := req.files["attachment"]; len(got) != 1 || got[0] != "answers.txt=яблоко" {
+		t.Errorf("attachment parts = %q", got)
+	}
+}
+
+func TestWebhookWrongSecret(t *testing.T) {
+	srv := newWebhookReceiver(t, "s3cret", make(chan webhookRequest, 1))
+	wh, err := NewWebhook("hook", config.WebhookSink{URL: srv.URL, Secret: "other"})
+	if err != nil {
+		t.Fatalf("NewWebhook: %v", err)
+	}
+	// The receiver rejects the signature, and the delivery fails so that it is retried.
+	if err := wh.Deliver(context.Background(), Delivery{DigestID: "d1"}); err == nil {
+		t.Fatal("Deliver with a wrong secret succeeded")
+	}
+}
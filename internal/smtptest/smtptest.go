@@ -0,0 +1,434 @@
+// Package smtptest provides an in-process SMTP server that captures the messages sent to it,
+// for end-to-end tests of the mail sender. It offers neither AUTH nor STARTTLS, so clients
+// send in plain text without logging in.
+package smtptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server is an SMTP server listening on a random local port.
+type Server struct {
+	Host string
+	Port int
+
+	ln       net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	messages []*Message
+	received chan struct{}
+}
+
+// Message is a captured message.
+type Message struct {
+	// From and To are the envelope sender and recipients.
+	From string
+	To   []string
+	Raw  []byte
+
+	Header mail.Header
+	// Subject is the decoded subject.
+	Subject string
+	// Parts are the leaf parts of the MIME tree in order, with decoded content.
+	Parts []Part
+}
+
+// Part is a leaf MIME part.
+type Part struct {
+	Header      textproto.MIMEHeader
+	ContentType string
+	// Filename is the name of an attached or embedded file.
+	Filename string
+	Data     []byte
+}
+
+// NewServer starts a server; it is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("smtptest: listen: %v", err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	s := &Server{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		ln:       ln,
+		conns:    make(map[net.Conn]struct{}),
+		received: make(chan struct{}, 1),
+	}
+	s.wg.Add(1)
+	go s.serve(t)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Addr returns the host:port address of the server.
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Close stops the server, ends the open sessions and waits for them.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Messages returns the messages received so far.
+func (s *Server) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Message(nil), s.messages...)
+}
+
+// Reset drops the received messages.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+}
+
+// WaitMessages waits up to a few seconds for n messages and fails the test unless exactly n
+// have arrived.
+func (s *Server) WaitMessages(t testing.TB, n int) []*Message {
+	t.Helper()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		msgs := s.Messages()
+		if len(msgs) >= n {
+			if len(msgs) > n {
+				t.Fatalf("smtptest: got %d messages, want %d", len(msgs), n)
+			}
+			return msgs
+		}
+		select {
+		case <-s.received:
+		case <-deadline:
+			t.Fatalf("smtptest: got %d messages, want %d", len(msgs), n)
+		}
+	}
+}
+
+func (s *Server) serve(t testing.TB) {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			err := s.session(conn)
+			if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				t.Errorf("smtptest: %v", err)
+			}
+		}()
+	}
+}
+
+// session speaks the minimal SMTP a client needs to send mail.
+func (s *Server) session(conn net.Conn) error {
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...any) error {
+		return tp.PrintfLine(format, args...)
+	}
+
+	if err := reply("220 smtptest ready"); err != nil {
+		return err
+	}
+	var (
+		from string
+		to   []string
+	)
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return err
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			err = reply("250-smtptest\r\n250 8BITMIME")
+		case "HELO", "NOOP":
+			err = reply("250 OK")
+		case "MAIL":
+			from, to = address(arg), nil
+			err = reply("250 OK")
+		case "RCPT":
+			to = append(to, address(arg))
+			err = reply("250 OK")
+		case "DATA":
+			if err := reply("354 end data with <CR><LF>.<CR><LF>"); err != nil {
+				return err
+			}
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return err
+			}
+			msg, err := Parse(from, to, crlf(data))
+			if err != nil {
+				_ = reply("554 %v", err)
+				return err
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			select {
+			case s.received <- struct{}{}:
+			default:
+			}
+			err = reply("250 OK")
+		case "RSET":
+			from, to = "", nil
+			err = reply("250 OK")
+		case "QUIT":
+			_ = reply("221 bye")
+			return nil
+		default:
+			err = reply("502 command not implemented")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// address extracts the address of a "FROM:<a@b> BODY=8BITMIME" argument.
+func address(arg string) string {
+	_, rest, _ := strings.Cut(arg, ":")
+	rest = strings.TrimSpace(rest)
+	if i := strings.IndexByte(rest, '>'); strings.HasPrefix(rest, "<") && i > 0 {
+		return rest[1:i]
+	}
+	return rest
+}
+
+// crlf restores the CRLF line endings the dot reader turns into LF.
+func crlf(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+}
+
+// Parse parses a raw message with its envelope.
+func Parse(from string, to []string, raw []byte) (*Message, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parse message: %w", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		return nil, fmt.Errorf("decode subject: %w", err)
+	}
+	msg := &Message{From: from, To: to, Raw: raw, Header: m.Header, Subject: subject}
+
+	header := textproto.MIMEHeader(m.Header)
+	if err := msg.addPart(header, m.Body); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *Message) addPart(header textproto.MIMEHeader, body io.Reader) error {
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("content type %q: %w", contentType, err)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("multipart: %w", err)
+			}
+			if err := m.addPart(p.Header, p); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, newlineStripper{bufio.NewReader(body)})
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("part %s: %w", mediaType, err)
+	}
+
+	filename := params["name"]
+	if _, dparams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		if name := dparams["filename"]; name != "" {
+			filename = name
+		}
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	m.Parts = append(m.Parts, Part{
+		Header: header, ContentType: mediaType, Filename: filename, Data: data,
+	})
+	return nil
+}
+
+// newlineStripper drops the line breaks of base64 content.
+type newlineStripper struct {
+	r *bufio.Reader
+}
+
+func (n newlineStripper) Read(p []byte) (int, error) {
+	i := 0
+	for i < len(p) {
+		b, err := n.r.ReadByte()
+		if err != nil {
+			return i, err
+		}
+		if b == '\r' || b == '\n' {
+			continue
+		}
+		p[i] = b
+		i++
+	}
+	return i, nil
+}
+
+// Body returns the content of the first part of the media type, such as "text/html".
+func (m *Message) Body(mediaType string) (string, bool) {
+	for _, p := range m.Parts {
+		if p.ContentType == mediaType && p.Filename == "" {
+			return string(p.Data), true
+		}
+	}
+	return "", false
+}
+
+// File returns the attached or embedded file with the name.
+func (m *Message) File(name string) (Part, bool) {
+	for _, p := range m.Parts {
+		if p.Filename == name {
+			return p, true
+		}
+	}
+	return Part{}, false
+}
+
+// Files returns the names of the attached and embedded files in order.
+func (m *Message) Files() []string {
+	var names []string
+	for _, p := range m.Parts {
+		if p.Filename != "" {
+			names = append(names, p.Filename)
+		}
+	}
+	return names
+}
+
+// AssertSubject fails the test unless the decoded subject is want.
+func (m *Message) AssertSubject(t testing.TB, want string) {
+	t.Helper()
+	if m.Subject != want {
+		t.Errorf("subject = %q, want %q", m.Subject, want)
+	}
+}
+
+// AssertRecipients fails the test unless the envelope recipients and the To header both list
+// exactly want, in order.
+func (m *Message) AssertRecipients(t testing.TB, want ...string) {
+	t.Helper()
+	if strings.Join(m.To, ",") != strings.Join(want, ",") {
+		t.Errorf("envelope recipients = %v, want %v", m.To, want)
+	}
+	list, err := m.Header.AddressList("To")
+	if err != nil {
+		t.Errorf("To header: %v", err)
+		return
+	}
+	got := make([]string, 0, len(list))
+	for _, a := range list {
+		got = append(got, a.Address)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("To header = %v, want %v", got, want)
+	}
+}
+
+// AssertHeader fails the test unless the header field has the value.
+func (m *Message) AssertHeader(t testing.TB, key, want string) {
+	t.Helper()
+	if got := m.Header.Get(key); got != want {
+		t.Errorf("header %s = %q, want %q", key, got, want)
+	}
+}
+
+// AssertBodyContains fails the test unless the body of the media type contains every string.
+func (m *Message) AssertBodyContains(t testing.TB, mediaType string, want ...string) {
+	t.Helper()
+	body, ok := m.Body(mediaType)
+	if !ok {
+		t.Errorf("no %s body", mediaType)
+		return
+	}
+	for _, w := range want {
+		if !strings.Contains(body, w) {
+			t.Errorf("%s body does not contain %q:\n%s", mediaType, w, body)
+		}
+	}
+}
+
+// AssertFile fails the test unless the file is attached with exactly the content.
+func (m *Message) AssertFile(t testing.TB, name string, want []byte) {
+	t.Helper()
+	p, ok := m.File(name)
+	if !ok {
+		t.Errorf("no file %q, files: %v", name, m.Files())
+		return
+	}
+	if !bytes.Equal(p.Data, want) {
+		t.Errorf("file %q = %q, want %q", name, p.Data, want)
+	}
+}
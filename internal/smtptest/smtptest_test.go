@@ -0,0 +1,93 @@
+package smtptest
+
+import (
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+const multipartMessage = "From: me@example.com\r\n" +
+	"To: a@example.com, b@example.com\r\n" +
+	"Subject: =?UTF-8?q?Wort_des_Tages_=C3=BC?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/html; charset=UTF-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<p>caf=C3=A9</p>\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/csv; name=\"words.csv\"\r\n" +
+	"Content-Disposition: attachment; filename=\"words.csv\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"YXBwbGU7\r\n" +
+	"0Y/QsdC70L7QutC+Cg==\r\n" +
+	"--outer--\r\n"
+
+func TestServerCapturesMessages(t *testing.T) {
+	srv := NewServer(t)
+
+	to := []string{"a@example.com", "b@example.com"}
+	err := smtp.SendMail(srv.Addr(), nil, "me@example.com", to, []byte(multipartMessage))
+	if err != nil {
+		t.Fatalf("SendMail: %v", err)
+	}
+
+	msgs := srv.WaitMessages(t, 1)
+	msg := msgs[0]
+	if msg.From != "me@example.com" {
+		t.Errorf("envelope sender = %q", msg.From)
+	}
+	msg.AssertRecipients(t, to...)
+	msg.AssertSubject(t, "Wort des Tages ü")
+	msg.AssertBodyContains(t, "text/html", "<p>café</p>")
+	msg.AssertFile(t, "words.csv", []byte("apple;яблоко\n"))
+	if got := strings.Join(msg.Files(), ","); got != "words.csv" {
+		t.Errorf("files = %s", got)
+	}
+
+	srv.Reset()
+	if n := len(srv.Messages()); n != 0 {
+		t.Errorf("%d messages after Reset", n)
+	}
+}
+
+func TestServerSeparatesMessagesOfASession(t *testing.T) {
+	srv := NewServer(t)
+
+	c, err := smtp.Dial(srv.Addr())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+	for _, rcpt := range []string{"a@example.com", "b@example.com"} {
+		if err := c.Mail("me@example.com"); err != nil {
+			t.Fatalf("Mail: %v", err)
+		}
+		if err := c.Rcpt(rcpt); err != nil {
+			t.Fatalf("Rcpt: %v", err)
+		}
+		w, err := c.Data()
+		if err != nil {
+			t.Fatalf("Data: %v", err)
+		}
+		// A line starting with a dot is escaped by the client and restored by the server.
+		body := "To: " + rcpt + "\r\nSubject: hi\r\n\r\n.leading dot\r\n"
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	}
+	if err := c.Quit(); err != nil {
+		t.Fatalf("Quit: %v", err)
+	}
+
+	msgs := srv.WaitMessages(t, 2)
+	msgs[0].AssertRecipients(t, "a@example.com")
+	msgs[1].AssertRecipients(t, "b@example.com")
+	msgs[1].AssertBodyContains(t, "text/plain", ".leading dot")
+}
@@ -0,0 +1,108 @@
+package srs
+
+import (
+	"github.com/fentezi/export-word/internal/entity"
+	"math"
+	"time"
+)
+
+const (
+	fsrsDecay  = -0.5
+	fsrsFactor = 19.0 / 81.0
+
+	fsrsMinDifficulty = 1
+	fsrsMaxDifficulty = 10
+	fsrsMaxInterval   = 36500
+)
+
+// fsrsWeights are the default FSRS-4.5 parameters.
+var fsrsWeights = [17]float64{
+	0.4872, 1.4003, 3.7145, 13.8206, 5.1618, 1.2298, 0.8975, 0.031, 1.6474,
+	0.1367, 1.0461, 2.1072, 0.0793, 0.3246, 1.587, 0.2272, 2.8755,
+}
+
+// FSRS implements the Free Spaced Repetition Scheduler (version 4.5) with default weights.
+type FSRS struct {
+	retention float64
+	w         [17]float64
+}
+
+func NewFSRS(retention float64) FSRS {
+	if retention <= 0 || retention >= 1 {
+		retention = 0.9
+	}
+	return FSRS{retention: retention, w: fsrsWeights}
+}
+
+func (f FSRS) Schedule(state entity.SRS, grade Grade, now time.Time) entity.SRS {
+	next := state
+	g := float64(grade)
+
+	if state.Stability == 0 {
+		next.Stability = f.w[grade-1]
+		next.Difficulty = f.initDifficulty(g)
+	} else {
+		elapsed := 0.0
+		if !state.LastReview.IsZero() {
+			elapsed = math.Max(0, now.Sub(state.LastReview).Hours()/24)
+		}
+		r := math.Pow(1+fsrsFactor*elapsed/state.Stability, fsrsDecay)
+
+		next.Difficulty = f.nextDifficulty(state.Difficulty, g)
+		if grade == Again {
+			next.Stability = f.forgetStability(state.Difficulty, state.Stability, r)
+		} else {
+			next.Stability = f.recallStability(state.Difficulty, state.Stability, r, grade)
+		}
+	}
+
+	if grade == Again {
+		next.Repetitions = 0
+		next.Lapses++
+	} else {
+		next.Repetitions++
+	}
+
+	next.Interval = f.interval(next.Stability)
+	next.LastReview = now
+	next.Due = addDays(now, next.Interval)
+
+	return next
+}
+
+func (f FSRS) interval(stability float64) int {
+	days := stability / fsrsFactor * (math.Pow(f.retention, 1/fsrsDecay) - 1)
+	return int(math.Min(fsrsMaxInterval, math.Max(1, math.Round(days))))
+}
+
+func (f FSRS) initDifficulty(g float64) float64 {
+	return clamp(f.w[4]-(g-3)*f.w[5], fsrsMinDifficulty, fsrsMaxDifficulty)
+}
+
+func (f FSRS) nextDifficulty(d, g float64) float64 {
+	next := d - f.w[6]*(g-3)
+	// Mean reversion towards the initial difficulty of a "good" answer.
+	next = f.w[7]*f.initDifficulty(3) + (1-f.w[7])*next
+	return clamp(next, fsrsMinDifficulty, fsrsMaxDifficulty)
+}
+
+func (f FSRS) recallStability(d, s, r float64, grade Grade) float64 {
+	hardPenalty, easyBonus := 1.0, 1.0
+	if grade == Hard {
+		hardPenalty = f.w[15]
+	}
+	if grade == Easy {
+		easyBonus = f.w[16]
+	}
+
+	return s * (1 + math.Exp(f.w[8])*(11-d)*math.Pow(s, -f.w[9])*
+		(math.Exp(f.w[10]*(1-r))-1)*hardPenalty*easyBonus)
+}
+
+func (f FSRS) forgetStability(d, s, r float64) float64 {
+	return f.w[11] * math.Pow(d, -f.w[12]) * (math.Pow(s+1, f.w[13]) - 1) * math.Exp(f.w[14]*(1-r))
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Min(hi, math.Max(lo, v))
+}
@@ -0,0 +1,59 @@
+package srs
+
+import (
+	"github.com/fentezi/export-word/internal/entity"
+	"math"
+	"time"
+)
+
+const (
+	sm2InitialEase = 2.5
+	sm2MinEase     = 1.3
+)
+
+// SM2 implements the SuperMemo-2 algorithm. Grades map to SM-2 qualities 1, 3, 4 and 5.
+type SM2 struct{}
+
+func (SM2) Schedule(state entity.SRS, grade Grade, now time.Time) entity.SRS {
+	q := sm2Quality(grade)
+	next := state
+	if next.Ease == 0 {
+		next.Ease = sm2InitialEase
+	}
+
+	if q < 3 {
+		next.Repetitions = 0
+		next.Interval = 1
+		next.Lapses++
+	} else {
+		switch next.Repetitions {
+		case 0:
+			next.Interval = 1
+		case 1:
+			next.Interval = 6
+		default:
+			next.Interval = int(math.Round(float64(next.Interval) * next.Ease))
+		}
+		next.Repetitions++
+	}
+
+	diff := float64(5 - q)
+	next.Ease = math.Max(sm2MinEase, next.Ease+0.1-diff*(0.08+diff*0.02))
+	next.LastReview = now
+	next.Due = addDays(now, next.Interval)
+
+	return next
+}
+
+func sm2Quality(grade Grade) int {
+	switch grade {
+	case Again:
+		return 1
+	case Hard:
+		return 3
+	case Easy:
+		return 5
+	default:
+		return 4
+	}
+}
@@ -0,0 +1,84 @@
+package srs
+
+import (
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"strings"
+	"time"
+)
+
+const (
+	AlgorithmSM2  = "sm2"
+	AlgorithmFSRS = "fsrs"
+
+	day = 24 * time.Hour
+)
+
+// Grade is the answer given when reviewing a word.
+type Grade int
+
+const (
+	Again Grade = iota + 1
+	Hard
+	Good
+	Easy
+)
+
+func (g Grade) String() string {
+	switch g {
+	case Again:
+		return "again"
+	case Hard:
+		return "hard"
+	case Good:
+		return "good"
+	case Easy:
+		return "easy"
+	default:
+		return fmt.Sprintf("grade(%d)", int(g))
+	}
+}
+
+// ParseGrade parses "again", "hard", "good" or "easy".
+func ParseGrade(s string) (Grade, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "again":
+		return Again, nil
+	case "hard":
+		return Hard, nil
+	case "good":
+		return Good, nil
+	case "easy":
+		return Easy, nil
+	default:
+		return 0, fmt.Errorf("unknown grade %q", s)
+	}
+}
+
+// Scheduler computes the state of a word after a review.
+type Scheduler interface {
+	Schedule(state entity.SRS, grade Grade, now time.Time) entity.SRS
+}
+
+// New returns the scheduler for the algorithm name. retention is the target probability of
+// recall and is only used by FSRS.
+func New(algorithm string, retention float64) (Scheduler, error) {
+	switch strings.ToLower(algorithm) {
+	case "", AlgorithmSM2:
+		return SM2{}, nil
+	case AlgorithmFSRS:
+		return NewFSRS(retention), nil
+	default:
+		return nil, fmt.Errorf("srs.New: unknown algorithm %q", algorithm)
+	}
+}
+
+// DueBy returns the end of the day containing now, so that everything due today is included.
+func DueBy(now time.Time) time.Time {
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+}
+
+func addDays(now time.Time, days int) time.Time {
+	return now.Add(time.Duration(days) * day)
+}
@@ -0,0 +1,252 @@
+package srs
+
+import (
+	"github.com/fentezi/export-word/internal/entity"
+	"math"
+	"testing"
+	"time"
+)
+
+var reviewTime = time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+
+func approx(got, want float64) bool {
+	return math.Abs(got-want) < 1e-4
+}
+
+func TestSM2Schedule(t *testing.T) {
+	tests := []struct {
+		name  string
+		state entity.SRS
+		grade Grade
+		// want is the expected state; Due and LastReview follow from Interval and are checked
+		// separately.
+		want entity.SRS
+	}{
+		{
+			name:  "first review again",
+			grade: Again,
+			want:  entity.SRS{Interval: 1, Repetitions: 0, Lapses: 1, Ease: 1.96},
+		},
+		{
+			name:  "first review hard",
+			grade: Hard,
+			want:  entity.SRS{Interval: 1, Repetitions: 1, Ease: 2.36},
+		},
+		{
+			name:  "first review good",
+			grade: Good,
+			want:  entity.SRS{Interval: 1, Repetitions: 1, Ease: 2.5},
+		},
+		{
+			name:  "first review easy",
+			grade: Easy,
+			want:  entity.SRS{Interval: 1, Repetitions: 1, Ease: 2.6},
+		},
+		{
+			name:  "second review good",
+			state: entity.SRS{Interval: 1, Repetitions: 1, Ease: 2.5},
+			grade: Good,
+			want:  entity.SRS{Interval: 6, Repetitions: 2, Ease: 2.5},
+		},
+		{
+			name:  "third review good multiplies by the ease",
+			state: entity.SRS{Interval: 6, Repetitions: 2, Ease: 2.5},
+			grade: Good,
+			want:  entity.SRS{Interval: 15, Repetitions: 3, Ease: 2.5},
+		},
+		{
+			name:  "third review easy rounds the interval",
+			state: entity.SRS{Interval: 6, Repetitions: 2, Ease: 2.6},
+			grade: Easy,
+			want:  entity.SRS{Interval: 16, Repetitions: 3, Ease: 2.7},
+		},
+		{
+			name:  "third review hard uses the ease before the review",
+			state: entity.SRS{Interval: 6, Repetitions: 2, Ease: 2.5},
+			grade: Hard,
+			want:  entity.SRS{Interval: 15, Repetitions: 3, Ease: 2.36},
+		},
+		{
+			name:  "lapse resets the repetitions",
+			state: entity.SRS{Interval: 15, Repetitions: 3, Lapses: 1, Ease: 2.5},
+			grade: Again,
+			want:  entity.SRS{Interval: 1, Repetitions: 0, Lapses: 2, Ease: 1.96},
+		},
+		{
+			name:  "ease does not drop below 1.3",
+			state: entity.SRS{Interval: 1, Repetitions: 0, Lapses: 4, Ease: 1.4},
+			grade: Again,
+			want:  entity.SRS{Interval: 1, Repetitions: 0, Lapses: 5, Ease: 1.3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SM2{}.Schedule(tt.state, tt.grade, reviewTime)
+
+			if got.Interval != tt.want.Interval {
+				t.Errorf("interval = %d, want %d", got.Interval, tt.want.Interval)
+			}
+			if got.Repetitions != tt.want.Repetitions {
+				t.Errorf("repetitions = %d, want %d", got.Repetitions, tt.want.Repetitions)
+			}
+			if got.Lapses != tt.want.Lapses {
+				t.Errorf("lapses = %d, want %d", got.Lapses, tt.want.Lapses)
+			}
+			if !approx(got.Ease, tt.want.Ease) {
+				t.Errorf("ease = %.4f, want %.4f", got.Ease, tt.want.Ease)
+			}
+			if !got.LastReview.Equal(reviewTime) {
+				t.Errorf("last review = %s, want %s", got.LastReview, reviewTime)
+			}
+			checkDue(t, got)
+		})
+	}
+}
+
+func TestFSRSSchedule(t *testing.T) {
+	// afterGood is the state after a first "good" answer; it is reviewed again four days later,
+	// when its retrievability is 0.8935.
+	afterGood := entity.SRS{
+		Interval: 4, Repetitions: 1, Stability: 3.7145, Difficulty: 5.1618, LastReview: reviewTime,
+	}
+	later := reviewTime.Add(4 * day)
+
+	tests := []struct {
+		name      string
+		retention float64
+		state     entity.SRS
+		grade     Grade
+		now       time.Time
+		want      entity.SRS
+	}{
+		{
+			name:  "first review again",
+			grade: Again,
+			want:  entity.SRS{Interval: 1, Lapses: 1, Stability: 0.4872, Difficulty: 7.6214},
+		},
+		{
+			name:  "first review hard",
+			grade: Hard,
+			want:  entity.SRS{Interval: 1, Repetitions: 1, Stability: 1.4003, Difficulty: 6.3916},
+		},
+		{
+			name:  "first review good",
+			grade: Good,
+			want:  entity.SRS{Interval: 4, Repetitions: 1, Stability: 3.7145, Difficulty: 5.1618},
+		},
+		{
+			name:  "first review easy",
+			grade: Easy,
+			want:  entity.SRS{Interval: 14, Repetitions: 1, Stability: 13.8206, Difficulty: 3.932},
+		},
+		{
+			name:      "lower retention lengthens the interval",
+			retention: 0.8,
+			grade:     Good,
+			want:      entity.SRS{Interval: 9, Repetitions: 1, Stability: 3.7145, Difficulty: 5.1618},
+		},
+		{
+			name:  "second review again",
+			state: afterGood,
+			grade: Again,
+			now:   later,
+			want: entity.SRS{
+				Interval: 1, Lapses: 1, Stability: 1.433234, Difficulty: 6.901155,
+			},
+		},
+		{
+			name:  "second review hard",
+			state: afterGood,
+			grade: Hard,
+			now:   later,
+			want: entity.SRS{
+				Interval: 6, Repetitions: 2, Stability: 6.234966, Difficulty: 6.031478,
+			},
+		},
+		{
+			name:  "second review good",
+			state: afterGood,
+			grade: Good,
+			now:   later,
+			want: entity.SRS{
+				Interval: 15, Repetitions: 2, Stability: 14.808101, Difficulty: 5.1618,
+			},
+		},
+		{
+			name:  "second review easy",
+			state: afterGood,
+			grade: Easy,
+			now:   later,
+			want: entity.SRS{
+				Interval: 36, Repetitions: 2, Stability: 35.614148, Difficulty: 4.292123,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := tt.now
+			if now.IsZero() {
+				now = reviewTime
+			}
+			retention := tt.retention
+			if retention == 0 {
+				retention = 0.9
+			}
+			got := NewFSRS(retention).Schedule(tt.state, tt.grade, now)
+
+			if got.Interval != tt.want.Interval {
+				t.Errorf("interval = %d, want %d", got.Interval, tt.want.Interval)
+			}
+			if got.Repetitions != tt.want.Repetitions {
+				t.Errorf("repetitions = %d, want %d", got.Repetitions, tt.want.Repetitions)
+			}
+			if got.Lapses != tt.want.Lapses {
+				t.Errorf("lapses = %d, want %d", got.Lapses, tt.want.Lapses)
+			}
+			if !approx(got.Stability, tt.want.Stability) {
+				t.Errorf("stability = %.6f, want %.6f", got.Stability, tt.want.Stability)
+			}
+			if !approx(got.Difficulty, tt.want.Difficulty) {
+				t.Errorf("difficulty = %.6f, want %.6f", got.Difficulty, tt.want.Difficulty)
+			}
+			if !got.LastReview.Equal(now) {
+				t.Errorf("last review = %s, want %s", got.LastReview, now)
+			}
+			checkDue(t, got)
+		})
+	}
+}
+
+func TestFSRSRetentionDefault(t *testing.T) {
+	for _, retention := range []float64{0, -1, 1, 1.5} {
+		if got := NewFSRS(retention).retention; got != 0.9 {
+			t.Errorf("NewFSRS(%v) retention = %v, want 0.9", retention, got)
+		}
+	}
+}
+
+func TestDueBy(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{reviewTime, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 12, 31, 23, 59, 0, 0, loc), time.Date(2027, 1, 1, 0, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		if got := DueBy(tt.now); !got.Equal(tt.want) {
+			t.Errorf("DueBy(%s) = %s, want %s", tt.now, got, tt.want)
+		}
+	}
+}
+
+// checkDue checks that the word is due Interval days after its review.
+func checkDue(t *testing.T, got entity.SRS) {
+	t.Helper()
+	if want := got.LastReview.Add(time.Duration(got.Interval) * day); !got.Due.Equal(want) {
+		t.Errorf("due = %s, want %s", got.Due, want)
+	}
+}
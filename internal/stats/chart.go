@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+)
+
+const (
+	chartWidth  = 720
+	chartHeight = 280
+	chartMargin = 36
+)
+
+var (
+	chartBackground = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	chartAxis       = color.RGBA{R: 120, G: 120, B: 120, A: 255}
+	chartBar        = color.RGBA{R: 66, G: 133, B: 244, A: 255}
+	chartText       = color.RGBA{R: 40, G: 40, B: 40, A: 255}
+)
+
+// Chart renders the counts as a PNG bar chart, labelling the maximum and every seventh bar
+// counting back from the last one.
+func Chart(title string, counts []entity.Count) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(chartBackground), image.Point{}, draw.Src)
+
+	var peak int64
+	for _, c := range counts {
+		peak = max(peak, c.Count)
+	}
+
+	left, right := chartMargin, chartWidth-chartMargin/2
+	top, bottom := chartMargin, chartHeight-chartMargin
+	fill(img, image.Rect(left, bottom, right, bottom+1), chartAxis)
+	fill(img, image.Rect(left-1, top, left, bottom), chartAxis)
+
+	label(img, left, top/2+4, title)
+	label(img, 4, top+4, strconv.FormatInt(peak, 10))
+	label(img, 4, bottom+4, "0")
+
+	if n := len(counts); n > 0 && peak > 0 {
+		slot := (right - left) / n
+		gap := max(1, slot/5)
+		for i, c := range counts {
+			x := left + i*slot
+			h := int(int64(bottom-top) * c.Count / peak)
+			fill(img, image.Rect(x+gap, bottom-h, x+slot-gap, bottom), chartBar)
+			if (n-1-i)%7 == 0 {
+				label(img, x, bottom+16, shortDay(c.Key))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("stats.Chart: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+func label(img *image.RGBA, x, y int, text string) {
+	d := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(chartText),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+// shortDay turns "2006-01-02" into "01-02".
+func shortDay(key string) string {
+	if len(key) == len(dayLayout) {
+		return key[5:]
+	}
+	return key
+}
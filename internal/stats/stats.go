@@ -0,0 +1,169 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"github.com/fentezi/export-word/internal/entity"
+	"github.com/fentezi/export-word/internal/srs"
+	"time"
+)
+
+const (
+	dayFormat     = "%Y-%m-%d"
+	weekFormat    = "%G-W%V"
+	dayLayout     = "2006-01-02"
+	langSeparator = "-"
+	reportWeeks   = 12
+	defaultDays   = 30
+	fieldSource   = "source"
+	fieldLangFrom = "langFrom"
+	fieldLangTo   = "langTo"
+)
+
+// Store is the part of the repository the statistics are computed from.
+type Store interface {
+	CountWordsByDate(
+		ctx context.Context, format string, since time.Time, timezone string,
+	) ([]entity.Count, error)
+	CountWordsByFields(ctx context.Context, sep string, fields ...string) ([]entity.Count, error)
+	CountWords(ctx context.Context, dueBy time.Time) (total, unsent, due int64, err error)
+}
+
+type Backlog struct {
+	Unsent int64 `json:"unsent"`
+	Due    int64 `json:"due"`
+}
+
+// Streak counts consecutive days with at least one new word.
+type Streak struct {
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
+type Report struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Total       int64          `json:"total"`
+	Backlog     Backlog        `json:"backlog"`
+	Streak      Streak         `json:"streak"`
+	PerDay      []entity.Count `json:"per_day"`
+	PerWeek     []entity.Count `json:"per_week"`
+	ByLanguage  []entity.Count `json:"by_language"`
+	BySource    []entity.Count `json:"by_source"`
+}
+
+// Collector builds reports in the given location.
+type Collector struct {
+	store Store
+	loc   *time.Location
+	days  int
+}
+
+// New returns a collector reporting the last days days (30 when zero) in the named timezone.
+func New(store Store, timezone string, days int) (*Collector, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("stats.New: %w", err)
+	}
+	if days <= 0 {
+		days = defaultDays
+	}
+	return &Collector{store: store, loc: loc, days: days}, nil
+}
+
+func (c *Collector) Report(ctx context.Context) (Report, error) {
+	const op = "stats.Report"
+
+	now := time.Now().In(c.loc)
+	report := Report{GeneratedAt: now}
+
+	total, unsent, due, err := c.store.CountWords(ctx, srs.DueBy(now))
+	if err != nil {
+		return Report{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	allDays, err := c.store.CountWordsByDate(ctx, dayFormat, time.Time{}, c.loc.String())
+	if err != nil {
+		return Report{}, fmt.Errorf("%s: %w", op, err)
+	}
+	report.Total = total
+	report.Backlog = Backlog{Unsent: unsent, Due: due}
+	report.Streak = streak(allDays, now)
+	report.PerDay = lastDays(allDays, now, c.days)
+
+	weekStart := startOfDay(now).AddDate(0, 0, -7*(reportWeeks-1)-int(isoWeekday(now)-1))
+	report.PerWeek, err = c.store.CountWordsByDate(ctx, weekFormat, weekStart, c.loc.String())
+	if err != nil {
+		return Report{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	report.ByLanguage, err = c.store.CountWordsByFields(
+		ctx, langSeparator, fieldLangFrom, fieldLangTo,
+	)
+	if err != nil {
+		return Report{}, fmt.Errorf("%s: %w", op, err)
+	}
+	report.BySource, err = c.store.CountWordsByFields(ctx, "", fieldSource)
+	if err != nil {
+		return Report{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return report, nil
+}
+
+// lastDays returns one entry per day of the last n days up to now, including empty days.
+func lastDays(counts []entity.Count, now time.Time, n int) []entity.Count {
+	byDay := make(map[string]int64, len(counts))
+	for _, c := range counts {
+		byDay[c.Key] = c.Count
+	}
+
+	days := make([]entity.Count, 0, n)
+	start := startOfDay(now).AddDate(0, 0, -(n - 1))
+	for i := 0; i < n; i++ {
+		key := start.AddDate(0, 0, i).Format(dayLayout)
+		days = append(days, entity.Count{Key: key, Count: byDay[key]})
+	}
+	return days
+}
+
+// streak computes the current and longest runs of consecutive days from sorted daily counts.
+// The current streak is still alive when the last active day is today or yesterday.
+func streak(days []entity.Count, now time.Time) Streak {
+	var (
+		st   Streak
+		run  int
+		prev time.Time
+	)
+	for _, d := range days {
+		day, err := time.ParseInLocation(dayLayout, d.Key, now.Location())
+		if err != nil || d.Count == 0 {
+			continue
+		}
+		if !prev.IsZero() && day.Equal(prev.AddDate(0, 0, 1)) {
+			run++
+		} else {
+			run = 1
+		}
+		st.Longest = max(st.Longest, run)
+		prev = day
+	}
+
+	today := startOfDay(now)
+	if !prev.IsZero() && (prev.Equal(today) || prev.Equal(today.AddDate(0, 0, -1))) {
+		st.Current = run
+	}
+	return st
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// isoWeekday returns 1 for Monday through 7 for Sunday.
+func isoWeekday(t time.Time) time.Weekday {
+	if t.Weekday() == time.Sunday {
+		return 7
+	}
+	return t.Weekday()
+}
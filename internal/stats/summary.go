@@ -0,0 +1,61 @@
+package stats
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// ChartName is the content ID of the chart embedded in the summary email.
+const ChartName = "chart.png"
+
+var summaryTemplate = template.Must(template.New("summary").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+<h2>Weekly progress</h2>
+<p>{{.Report.Total}} words collected, {{.Week}} this week.<br>
+{{.Report.Backlog.Unsent}} never sent, {{.Report.Backlog.Due}} due for review today.<br>
+Streak: {{.Report.Streak.Current}} days (longest {{.Report.Streak.Longest}}).</p>
+<img src="cid:{{.Chart}}" alt="Words per day" width="720" height="280">
+{{- if .Report.ByLanguage}}
+<h3>By language pair</h3>
+<ul>{{range .Report.ByLanguage}}<li>{{.Key}}: {{.Count}}</li>{{end}}</ul>
+{{- end}}
+{{- if .Report.BySource}}
+<h3>By source</h3>
+<ul>{{range .Report.BySource}}<li>{{.Key}}: {{.Count}}</li>{{end}}</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+// Summary renders the weekly summary email and the chart it embeds as ChartName.
+func Summary(report Report) (string, []byte, error) {
+	const op = "stats.Summary"
+
+	chart, err := Chart(fmt.Sprintf("Words per day, last %d days", len(report.PerDay)), report.PerDay)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var week int64
+	year, w := report.GeneratedAt.ISOWeek()
+	current := fmt.Sprintf("%d-W%02d", year, w)
+	for _, c := range report.PerWeek {
+		if c.Key == current {
+			week = c.Count
+		}
+	}
+
+	var sb strings.Builder
+	err = summaryTemplate.Execute(&sb, struct {
+		Report Report
+		Week   int64
+		Chart  string
+	}{Report: report, Week: week, Chart: ChartName})
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sb.String(), chart, nil
+}
@@ -0,0 +1,124 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fentezi/export-word/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	rootName   = "export-word"
+	dirPrefix  = "export-"
+	fileLayout = "2006-01-02T15-04"
+	dirLayout  = "20060102T150405"
+	// staleAfter is how long an unretained export directory may exist before Prune treats it
+	// as left behind by a crash rather than in use by a running export.
+	staleAfter = 24 * time.Hour
+)
+
+// Workspace manages the directories exports are written to. Every export gets its own
+// directory, so that concurrent exports never share a file and nothing is written to the
+// working directory of the process.
+type Workspace struct {
+	root      string
+	retention time.Duration
+}
+
+// Export is the directory of a single export.
+type Export struct {
+	Dir     string
+	Created time.Time
+}
+
+// New creates the root directory, the system temporary directory when none is configured.
+func New(cfg config.Workspace) (*Workspace, error) {
+	const op = "workspace.New"
+
+	root := cfg.Dir
+	if root == "" {
+		root = filepath.Join(os.TempDir(), rootName)
+	}
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return &Workspace{root: root, retention: cfg.Retention}, nil
+}
+
+// Create makes a new, uniquely named directory for an export started at now.
+func (w *Workspace) Create(now time.Time) (Export, error) {
+	const op = "workspace.Create"
+
+	dir, err := os.MkdirTemp(w.root, dirPrefix+now.UTC().Format(dirLayout)+"-")
+	if err != nil {
+		return Export{}, fmt.Errorf("%s: %w", op, err)
+	}
+	return Export{Dir: dir, Created: now}, nil
+}
+
+// Path returns the dated path of a file of the export, e.g. "words-2026-10-16T09-00.csv".
+func (e Export) Path(name, ext string) string {
+	return filepath.Join(e.Dir, name+"-"+e.Created.Format(fileLayout)+"."+ext)
+}
+
+// Release removes the export directory unless past exports are retained. Retained exports
+// are removed by Prune once they are older than the retention.
+func (w *Workspace) Release(e Export) error {
+	if w.retention > 0 {
+		return nil
+	}
+	if err := os.RemoveAll(e.Dir); err != nil {
+		return fmt.Errorf("workspace.Release: %w", err)
+	}
+	return nil
+}
+
+// Prune removes the export directories last modified before now minus the retention and
+// returns how many were removed. Without a retention, exports are already removed on release
+// and only directories left behind by a crash, older than a day, are pruned. Directories in
+// inUse, by path, are kept however old they are.
+func (w *Workspace) Prune(now time.Time, inUse map[string]bool) (int, error) {
+	const op = "workspace.Prune"
+
+	entries, err := os.ReadDir(w.root)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keep := w.retention
+	if keep == 0 {
+		keep = staleAfter
+	}
+	cutoff := now.Add(-keep)
+	removed := 0
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), dirPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		dir := filepath.Join(w.root, entry.Name())
+		if !info.ModTime().Before(cutoff) || inUse[dir] {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return removed, fmt.Errorf("%s: %w", op, err)
+	}
+	return removed, nil
+}